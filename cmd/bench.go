@@ -0,0 +1,343 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// CmdBench represents the bench command
+var CmdBench = cli.Command{
+	Name:            "bench",
+	Usage:           "Run the benchmarks and compare them with a baseline",
+	Description:     `Run the benchmarks of the project packages, save the results into bin/bench and compare them with a baseline`,
+	Action:          runBench,
+	SkipFlagParsing: true,
+}
+
+const defaultBenchCount = 5
+
+// benchLineRegexp matches the result lines of the benchmarks, i.e. BenchmarkUser-8  1000  1234 ns/op  56 B/op
+var benchLineRegexp = regexp.MustCompile(`^(Benchmark\S*)\s+(\d+)\s+(.+)$`)
+
+// benchResult is the measurements of a benchmark in all the runs
+type benchResult struct {
+	Package string `json:"package"`
+	Name    string `json:"name"`
+	// Values are the measurements keyed by the unit, i.e. ns/op, B/op, allocs/op and MB/s
+	Values map[string][]float64 `json:"values"`
+}
+
+// fullName returns package.Name
+func (b *benchResult) fullName() string {
+	return b.Package + "." + b.Name
+}
+
+// units returns the units of the values in the order of the go test output
+func (b *benchResult) units() []string {
+	var order = map[string]int{"ns/op": 0, "MB/s": 1, "B/op": 2, "allocs/op": 3}
+	var units = make([]string, 0, len(b.Values))
+	for unit := range b.Values {
+		units = append(units, unit)
+	}
+	sort.Slice(units, func(i, j int) bool {
+		oi, ok := order[units[i]]
+		if !ok {
+			oi = len(order)
+		}
+		oj, ok := order[units[j]]
+		if !ok {
+			oj = len(order)
+		}
+		if oi != oj {
+			return oi < oj
+		}
+		return units[i] < units[j]
+	})
+	return units
+}
+
+// benchRun is the results of the benchmarks of a commit
+type benchRun struct {
+	Commit     string         `json:"commit"`
+	Time       time.Time      `json:"time"`
+	Benchmarks []*benchResult `json:"benchmarks"`
+}
+
+// parseBenchOutput parses the benchmarks in the output of go test -bench. The package of the
+// benchmarks is known from the following result line of the package.
+func parseBenchOutput(output []byte) []*benchResult {
+	var (
+		results []*benchResult
+		pending []*benchResult
+		byName  = make(map[string]*benchResult)
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if matches := testResultRegexp.FindStringSubmatch(line); matches != nil {
+			for _, b := range pending {
+				b.Package = matches[2]
+				results = append(results, b)
+			}
+			pending = nil
+			byName = make(map[string]*benchResult)
+			continue
+		}
+
+		matches := benchLineRegexp.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		fields := strings.Fields(matches[3])
+		if len(fields)%2 != 0 {
+			continue
+		}
+
+		b, ok := byName[matches[1]]
+		if !ok {
+			b = &benchResult{Name: matches[1], Values: make(map[string][]float64)}
+			byName[matches[1]] = b
+			pending = append(pending, b)
+		}
+		for i := 0; i < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				continue
+			}
+			b.Values[fields[i+1]] = append(b.Values[fields[i+1]], v)
+		}
+	}
+	return results
+}
+
+// benchDir returns the directory the results of the benchmarks are saved
+func benchDir(projectRoot string) string {
+	return filepath.Join(projectRoot, "bin", "bench")
+}
+
+// currentCommit returns the short sha of the current commit, the uncommitted changes are marked as -dirty
+func currentCommit(projectRoot string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--short", "HEAD")
+	cmd.Dir = projectRoot
+	bs, err := cmd.Output()
+	if err != nil {
+		return "", errors.New("cannot get the current commit, the project should be a git repository")
+	}
+	sha := strings.TrimSpace(string(bs))
+
+	cmd = exec.Command("git", "status", "--porcelain", "--untracked-files=no")
+	cmd.Dir = projectRoot
+	if bs, err = cmd.Output(); err == nil && len(bytes.TrimSpace(bs)) > 0 {
+		sha += "-dirty"
+	}
+	return sha, nil
+}
+
+// loadBenchRun loads the results of a commit in bin/bench or a file
+func loadBenchRun(projectRoot, baseline string) (*benchRun, error) {
+	p := filepath.Join(benchDir(projectRoot), baseline+".json")
+	if exist, _ := isFileExist(p); !exist {
+		p = baseline
+	}
+
+	bs, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load the baseline %s: %v", baseline, err)
+	}
+	var run benchRun
+	if err = json.Unmarshal(bs, &run); err != nil {
+		return nil, fmt.Errorf("cannot load the baseline %s: %v", baseline, err)
+	}
+	return &run, nil
+}
+
+// saveBenchRun saves the results into bin/bench/<commit>.json and returns the path
+func saveBenchRun(projectRoot string, run *benchRun) (string, error) {
+	bs, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	p := filepath.Join(benchDir(projectRoot), run.Commit+".json")
+	if err = os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return "", err
+	}
+	return p, ioutil.WriteFile(p, append(bs, '\n'), 0644)
+}
+
+// parseBenchCount parses the value of --count which should be positive
+func parseBenchCount(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad --count %s", s)
+	}
+	return n, nil
+}
+
+func runBench(ctx *cli.Context) error {
+	var args = make([]string, 0, len(ctx.Args()))
+	var baseline, bench string
+	var count int
+	var cmdArgs = ctx.Args()
+	for i := 0; i < len(cmdArgs); i++ {
+		arg := cmdArgs[i]
+		switch {
+		case arg == "--baseline" || arg == "--bench" || arg == "--count":
+			if i+1 >= len(cmdArgs) {
+				return fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			switch arg {
+			case "--baseline":
+				baseline = cmdArgs[i]
+			case "--bench":
+				bench = cmdArgs[i]
+			default:
+				n, err := parseBenchCount(cmdArgs[i])
+				if err != nil {
+					return err
+				}
+				count = n
+			}
+		case strings.HasPrefix(arg, "--baseline="):
+			baseline = strings.TrimPrefix(arg, "--baseline=")
+		case strings.HasPrefix(arg, "--bench="):
+			bench = strings.TrimPrefix(arg, "--bench=")
+		case strings.HasPrefix(arg, "--count="):
+			n, err := parseBenchCount(strings.TrimPrefix(arg, "--count="))
+			if err != nil {
+				return err
+			}
+			count = n
+		default:
+			if arg == "-v" {
+				showLog = true
+			}
+			args = append(args, arg)
+		}
+	}
+
+	_, projectRoot, err := analysisDirLevel()
+	if err != nil {
+		return err
+	}
+
+	if err = loadConfig(filepath.Join(projectRoot, "gop.yml")); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		count = config.Bench.Count
+	}
+	if count <= 0 {
+		count = defaultBenchCount
+	}
+	if bench == "" {
+		bench = "."
+	}
+
+	graph, err := loadPackageGraph(projectRoot, buildTags(args))
+	if err != nil {
+		return err
+	}
+
+	// the argument is a target of gop.yml or a project package
+	var pkgs []string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name := args[0]
+		args = args[1:]
+		for _, t := range config.Targets {
+			if t.Name == name {
+				pkgs = graph.selectPackages(t.Dir, nil)
+				break
+			}
+		}
+		if pkgs == nil {
+			if !hasArg(graph.projectPackages(), name) {
+				return fmt.Errorf("%s is neither a target nor a project package", name)
+			}
+			pkgs = []string{name}
+		}
+	} else {
+		pkgs = graph.selectPackages("", nil)
+	}
+	if len(pkgs) == 0 {
+		return errors.New("no packages to benchmark")
+	}
+
+	commit, err := currentCommit(projectRoot)
+	if err != nil {
+		return err
+	}
+	var old *benchRun
+	if baseline != "" {
+		if old, err = loadBenchRun(projectRoot, baseline); err != nil {
+			return err
+		}
+	}
+
+	envs := projectEnv(projectRoot)
+
+	benchArgs := append([]string{"-run", "^$", "-bench", bench, "-count", strconv.Itoa(count)}, args...)
+	output, err := runTestPackages(projectRoot, benchArgs, pkgs, envs, os.Stdout, nil)
+	if err != nil {
+		return err
+	}
+
+	run := &benchRun{
+		Commit:     commit,
+		Time:       time.Now().UTC(),
+		Benchmarks: parseBenchOutput(output),
+	}
+	if len(run.Benchmarks) == 0 {
+		return errors.New("no benchmarks are run")
+	}
+	p, err := saveBenchRun(projectRoot, run)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n=== %d benchmarks are saved to %s\n", len(run.Benchmarks), p)
+
+	if old == nil {
+		printBenchRun(os.Stdout, run)
+		return nil
+	}
+
+	fmt.Printf("=== Compared with %s\n", old.Commit)
+	deltas := compareBenchmarks(old, run)
+	printBenchDeltas(os.Stdout, deltas)
+
+	threshold := config.Bench.Threshold
+	if threshold <= 0 {
+		return nil
+	}
+	var regressions int
+	for _, d := range deltas {
+		if d.Regression() > threshold {
+			fmt.Printf("=== %s %s regressed %.2f%%, more than %.2f%%\n", d.Name, d.Unit, d.Regression(), threshold)
+			regressions++
+		}
+	}
+	if regressions > 0 {
+		return fmt.Errorf("%d benchmarks regressed more than %.2f%%", regressions, threshold)
+	}
+	return nil
+}
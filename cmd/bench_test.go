@@ -0,0 +1,73 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBenchOutput(t *testing.T) {
+	results := parseBenchOutput([]byte(`goos: linux
+goarch: amd64
+pkg: models
+BenchmarkUser-8   	 1000000	      1200 ns/op	      56 B/op	       2 allocs/op
+BenchmarkUser-8   	 1000000	      1300 ns/op	      56 B/op	       2 allocs/op
+PASS
+ok  	models	2.512s
+BenchmarkCopy-8   	    2000	    500000 ns/op	 200.00 MB/s
+PASS
+ok  	routes	1.100s
+`))
+
+	assert.EqualValues(t, []*benchResult{
+		{Package: "models", Name: "BenchmarkUser-8", Values: map[string][]float64{
+			"ns/op":     {1200, 1300},
+			"B/op":      {56, 56},
+			"allocs/op": {2, 2},
+		}},
+		{Package: "routes", Name: "BenchmarkCopy-8", Values: map[string][]float64{
+			"ns/op": {500000},
+			"MB/s":  {200},
+		}},
+	}, results)
+	assert.EqualValues(t, []string{"ns/op", "B/op", "allocs/op"}, results[0].units())
+}
+
+func TestBenchStats(t *testing.T) {
+	s := newBenchStats([]float64{100, 102, 98, 100, 1000})
+	assert.EqualValues(t, []float64{100, 102, 98, 100}, s.Values)
+	assert.EqualValues(t, 100, s.Mean)
+	assert.EqualValues(t, "100 ± 2%", s.String())
+
+	// all the arrangements of 5+5 samples are 252, the most extreme two are 2/252
+	assert.InDelta(t, 2.0/252, mannWhitneyU([]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}), 1e-9)
+	assert.EqualValues(t, 1, mannWhitneyU([]float64{1, 3, 5}, []float64{2, 4}))
+	assert.True(t, mannWhitneyU([]float64{1, 1, 2, 2, 3}, []float64{5, 5, 6, 6, 7}) < benchAlpha)
+
+	old := &benchRun{Benchmarks: []*benchResult{
+		{Package: "models", Name: "BenchmarkUser-8", Values: map[string][]float64{"ns/op": {100, 101, 99, 100, 100}}},
+	}}
+	cur := &benchRun{Benchmarks: []*benchResult{
+		{Package: "models", Name: "BenchmarkUser-8", Values: map[string][]float64{"ns/op": {120, 121, 119, 120, 120}}},
+		{Package: "models", Name: "BenchmarkNew-8", Values: map[string][]float64{"ns/op": {1}}},
+	}}
+	deltas := compareBenchmarks(old, cur)
+	assert.Len(t, deltas, 1)
+	assert.True(t, deltas[0].Significant())
+	assert.True(t, math.Abs(deltas[0].Regression()-20) < 1e-9)
+}
+
+func TestParseBenchCount(t *testing.T) {
+	n, err := parseBenchCount("5")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, n)
+	_, err = parseBenchCount("0")
+	assert.Error(t, err)
+	_, err = parseBenchCount("x")
+	assert.Error(t, err)
+}
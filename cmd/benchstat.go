@@ -0,0 +1,273 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+)
+
+// benchAlpha is the significance level of the changes like benchstat
+const benchAlpha = 0.05
+
+// benchStats are the statistics of the values of a benchmark after removing the outliers
+type benchStats struct {
+	Values []float64
+	Mean   float64
+	// Diff is the max difference from the mean in percentage
+	Diff float64
+}
+
+func newBenchStats(values []float64) benchStats {
+	values = removeOutliers(values)
+	var s = benchStats{Values: values}
+	if len(values) == 0 {
+		return s
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	s.Mean = sum / float64(len(values))
+	if s.Mean != 0 {
+		for _, v := range values {
+			if d := math.Abs(v-s.Mean) / s.Mean * 100; d > s.Diff {
+				s.Diff = d
+			}
+		}
+	}
+	return s
+}
+
+// String returns mean ± diff%
+func (s benchStats) String() string {
+	return fmt.Sprintf("%.4g ± %.0f%%", s.Mean, s.Diff)
+}
+
+// quantile returns the q quantile of the sorted values with the linear interpolation
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+// removeOutliers removes the values out of 1.5 times the interquartile range from the quartiles
+func removeOutliers(values []float64) []float64 {
+	if len(values) < 4 {
+		return values
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	q1, q3 := quantile(sorted, 0.25), quantile(sorted, 0.75)
+	lo, hi := q1-1.5*(q3-q1), q3+1.5*(q3-q1)
+
+	var kept []float64
+	for _, v := range values {
+		if v >= lo && v <= hi {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// mannWhitneyU returns the two-sided p-value of the Mann-Whitney U test of the samples. The exact
+// distribution is used for small samples without ties, otherwise the normal approximation is used.
+func mannWhitneyU(xs, ys []float64) float64 {
+	n1, n2 := len(xs), len(ys)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+
+	type sample struct {
+		v float64
+		x bool
+	}
+	var all = make([]sample, 0, n1+n2)
+	for _, v := range xs {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range ys {
+		all = append(all, sample{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].v < all[j].v
+	})
+
+	// the ranks of the ties are their average
+	var rankX, tieCorrection float64
+	var hasTies bool
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		if t := float64(j - i); t > 1 {
+			hasTies = true
+			tieCorrection += t*t*t - t
+		}
+		for k := i; k < j; k++ {
+			if all[k].x {
+				rankX += rank
+			}
+		}
+		i = j
+	}
+	u := rankX - float64(n1*(n1+1))/2
+
+	if !hasTies && n1*n2 <= 2500 {
+		return exactMannWhitneyP(n1, n2, int(u))
+	}
+
+	n := float64(n1 + n2)
+	mean := float64(n1*n2) / 2
+	variance := float64(n1*n2) / 12 * ((n + 1) - tieCorrection/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance)
+	if z < 0 {
+		z = 0
+	}
+	return math.Min(1, math.Erfc(z/math.Sqrt2))
+}
+
+// exactMannWhitneyP returns the two-sided p-value of u by counting the arrangements of the samples
+func exactMannWhitneyP(n1, n2, u int) float64 {
+	// counts[i][j][k] is the number of the arrangements of i xs and j ys whose U is k, only two
+	// layers of i are kept
+	maxU := n1 * n2
+	prev := make([][]float64, n2+1)
+	for j := range prev {
+		prev[j] = make([]float64, maxU+1)
+		prev[j][0] = 1
+	}
+	for i := 1; i <= n1; i++ {
+		cur := make([][]float64, n2+1)
+		for j := range cur {
+			cur[j] = make([]float64, maxU+1)
+			for k := 0; k <= i*j; k++ {
+				// the largest is an x which is larger than all j ys, or a y
+				if k >= j {
+					cur[j][k] += prev[j][k-j]
+				}
+				if j > 0 {
+					cur[j][k] += cur[j-1][k]
+				}
+			}
+		}
+		prev = cur
+	}
+
+	counts := prev[n2]
+	var total, lower, upper float64
+	for k, c := range counts {
+		total += c
+		if k <= u {
+			lower += c
+		}
+		if k >= u {
+			upper += c
+		}
+	}
+	return math.Min(1, 2*math.Min(lower, upper)/total)
+}
+
+// benchDelta is the change of a metric of a benchmark from the baseline
+type benchDelta struct {
+	Name  string
+	Unit  string
+	Old   benchStats
+	New   benchStats
+	Delta float64
+	P     float64
+}
+
+// Significant returns true if the change is not by chance
+func (d benchDelta) Significant() bool {
+	return d.P < benchAlpha
+}
+
+// Regression returns the percentage of the regression, it's 0 if the benchmark is not slower.
+// The higher values are better for the units of speed like MB/s.
+func (d benchDelta) Regression() float64 {
+	if !d.Significant() {
+		return 0
+	}
+	r := d.Delta
+	if d.Unit == "MB/s" {
+		r = -r
+	}
+	return math.Max(0, r)
+}
+
+// compareBenchmarks returns the changes of the benchmarks in both runs
+func compareBenchmarks(old, cur *benchRun) []benchDelta {
+	var olds = make(map[string]*benchResult, len(old.Benchmarks))
+	for _, b := range old.Benchmarks {
+		olds[b.fullName()] = b
+	}
+
+	var deltas []benchDelta
+	for _, b := range cur.Benchmarks {
+		o, ok := olds[b.fullName()]
+		if !ok {
+			continue
+		}
+		for _, unit := range b.units() {
+			if _, ok := o.Values[unit]; !ok {
+				continue
+			}
+			d := benchDelta{
+				Name: b.fullName(),
+				Unit: unit,
+				Old:  newBenchStats(o.Values[unit]),
+				New:  newBenchStats(b.Values[unit]),
+			}
+			if d.Old.Mean != 0 {
+				d.Delta = (d.New.Mean - d.Old.Mean) / d.Old.Mean * 100
+			}
+			d.P = mannWhitneyU(d.Old.Values, d.New.Values)
+			deltas = append(deltas, d)
+		}
+	}
+	return deltas
+}
+
+// printBenchDeltas prints the changes like benchstat, the insignificant changes are shown as ~
+func printBenchDeltas(w io.Writer, deltas []benchDelta) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tUNIT\tOLD\tNEW\tDELTA")
+	for _, d := range deltas {
+		delta := "~"
+		if d.Significant() {
+			delta = fmt.Sprintf("%+.2f%%", d.Delta)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s (p=%.3f n=%d+%d)\n", d.Name, d.Unit, d.Old, d.New,
+			delta, d.P, len(d.Old.Values), len(d.New.Values))
+	}
+	tw.Flush()
+}
+
+// printBenchRun prints the statistics of the benchmarks
+func printBenchRun(w io.Writer, run *benchRun) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tUNIT\tVALUE")
+	for _, b := range run.Benchmarks {
+		for _, unit := range b.units() {
+			s := newBenchStats(b.Values[unit])
+			fmt.Fprintf(tw, "%s\t%s\t%s (n=%d)\n", b.fullName(), unit, s, len(s.Values))
+		}
+	}
+	tw.Flush()
+}
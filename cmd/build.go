@@ -5,12 +5,19 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/urfave/cli"
 )
@@ -24,76 +31,155 @@ var CmdBuild = cli.Command{
 	SkipFlagParsing: true,
 }
 
-var curTarget *Target
-
-func analysisTarget(level int, targetName, projectRoot string) error {
+func analysisTarget(level int, targetName, projectRoot string) (*Target, error) {
 	if targetName == "" {
 		if level == dirLevelTarget {
-			wd, err := os.Getwd()
+			wd, err := workDir()
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			relPath, err := filepath.Rel(filepath.Join(projectRoot, "src"), wd)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
-			for _, t := range config.Targets {
-				if t.Dir == relPath {
-					curTarget = &t
-					break
+			// the target is the one whose directory contains the working directory
+			var found *Target
+			for i, t := range config.Targets {
+				if isInDir(filepath.FromSlash(t.Dir), relPath) && (found == nil || len(t.Dir) > len(found.Dir)) {
+					found = &config.Targets[i]
 				}
 			}
+			if found != nil {
+				var t = *found
+				return &t, nil
+			}
 
-			var name = filepath.Base(relPath)
-			if relPath == "main" {
+			// the target of a directory not configured is the first directory under src
+			dir := strings.SplitN(filepath.ToSlash(relPath), "/", 2)[0]
+			var name = dir
+			if dir == "main" {
 				name = filepath.Base(projectRoot)
 			}
 
-			curTarget = &Target{
+			return &Target{
 				Name: name,
-				Dir:  relPath,
-			}
+				Dir:  dir,
+			}, nil
 		}
-		if curTarget == nil {
-			curTarget = &config.Targets[0]
+
+		var t = config.Targets[0]
+		return &t, nil
+	}
+
+	for _, t := range config.Targets {
+		if t.Name == targetName || t.Dir == targetName {
+			return &t, nil
+		}
+	}
+
+	exist, _ := isDirExist(filepath.Join(projectRoot, "src", targetName))
+	if !exist {
+		return nil, errors.New("unknow target")
+	}
+
+	return &Target{
+		Name: targetName,
+		Dir:  targetName,
+	}, nil
+}
+
+// buildTarget runs go build for one target, all the output will be written to stdout and stderr.
+// If the target's inputs are not changed since last build and force is false, the build will be
+// skipped and false will be returned. If tmpPath is not empty, the binary and its fingerprint will be
+// written to tmpPath instead, so the caller could replace the binary after the build succeeded.
+func buildTarget(ctx *cli.Context, projectRoot string, target *Target, args []string, isWindows, ensureFlag, force bool, tmpPath string, stdout, stderr io.Writer) (bool, error) {
+	var find = -1
+	for i, arg := range args {
+		if arg == "-o" {
+			find = i
+		}
+	}
+
+	if ensureFlag {
+		globalGoPath, ok := os.LookupEnv("GOPATH")
+		if !ok {
+			return false, errors.New("Not found GOPATH")
+		}
+
+		if err := ensure(ctx, globalGoPath, projectRoot, target, false); err != nil {
+			return false, err
+		}
+	}
+
+	var ext string
+	if isWindows {
+		ext = ".exe"
+	}
+
+	var output = target.Name + ext
+	if find > -1 {
+		if find < len(args)-1 {
+			target.Name = args[find+1]
+			output = args[find+1]
+		} else {
+			args = append(args[:find], "-o", output)
 		}
 	} else {
-		for _, t := range config.Targets {
-			if t.Name == targetName {
-				curTarget = &t
-				break
-			}
-			if t.Dir == targetName {
-				curTarget = &t
-				break
+		args = append(args, "-o", output)
+	}
+
+	targetDir := filepath.Join(projectRoot, "src", target.Dir)
+	exePath := output
+	if !filepath.IsAbs(exePath) {
+		exePath = filepath.Join(targetDir, exePath)
+	}
+
+	envs := projectEnv(projectRoot)
+	fingerprint, err := targetFingerprint(projectRoot, target, args, envs)
+	if err != nil {
+		Fprintln(stdout, "Compute fingerprint failed:", err)
+	} else if !force && isUpToDate(exePath, fingerprint) {
+		fmt.Fprintln(stdout, target.Name, "is up to date")
+		return false, nil
+	}
+
+	if tmpPath != "" {
+		args = append([]string{}, args...)
+		for i := range args {
+			if args[i] == "-o" && i < len(args)-1 {
+				args[i+1] = tmpPath
 			}
 		}
+		exePath = tmpPath
+	}
 
-		if curTarget == nil {
-			exist, _ := isDirExist(filepath.Join(projectRoot, "src", targetName))
-			if !exist {
-				return errors.New("unknow target")
-			}
+	cmd := NewCommand("build").AddArguments(args...)
+	cmd.Env = envs
 
-			curTarget = &Target{
-				Name: targetName,
-				Dir:  targetName,
-			}
+	Fprintln(stdout, "Building", target.Name)
+	if err = cmd.RunInDirPipeline(targetDir, stdout, stderr); err != nil {
+		return true, err
+	}
+
+	if fingerprint != "" {
+		if err = saveFingerprint(exePath, fingerprint); err != nil {
+			return true, err
 		}
 	}
-	return nil
+	return true, nil
 }
 
-func runBuildNoCtx(ctx *cli.Context, args []string, isWindows, ensureFlag bool) error {
+// runBuildNoCtx builds the target according args, it returns the target and whether the binary is rebuilt
+func runBuildNoCtx(ctx *cli.Context, args []string, isWindows, ensureFlag, force bool) (*Target, bool, error) {
 	level, projectRoot, err := analysisDirLevel()
 	if err != nil {
-		return err
+		return nil, false, err
 	}
 
 	if err = loadConfig(filepath.Join(projectRoot, "gop.yml")); err != nil {
-		return err
+		return nil, false, err
 	}
 
 	var targetName string
@@ -102,84 +188,160 @@ func runBuildNoCtx(ctx *cli.Context, args []string, isWindows, ensureFlag bool)
 		args = args[1:]
 	}
 
-	if err = analysisTarget(level, targetName, projectRoot); err != nil {
+	target, err := analysisTarget(level, targetName, projectRoot)
+	if err != nil {
+		return nil, false, err
+	}
+
+	built, err := buildTarget(ctx, projectRoot, target, args, isWindows, ensureFlag, force, "", os.Stdout, os.Stderr)
+	if err != nil {
+		return nil, false, err
+	}
+	return target, built, nil
+}
+
+type buildResult struct {
+	Target   string
+	Err      error
+	Duration time.Duration
+}
+
+// writePrefixed writes every line of content to w with the prefix
+func writePrefixed(w io.Writer, prefix string, content []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fmt.Fprintf(w, "%s %s\n", prefix, scanner.Text())
+	}
+}
+
+// runBuildAll builds all the targets defined in gop.yml concurrently, at most jobs targets at the same time
+func runBuildAll(ctx *cli.Context, args []string, isWindows, ensureFlag, force bool, jobs int) error {
+	_, projectRoot, err := analysisDirLevel()
+	if err != nil {
 		return err
 	}
 
-	var find = -1
-	for i, arg := range args {
-		if arg == "-v" {
-			showLog = true
-		} else if arg == "-o" {
-			find = i
-		}
+	if err = loadConfig(filepath.Join(projectRoot, "gop.yml")); err != nil {
+		return err
 	}
 
+	// ensure writes to the shared vendor directory, so it cannot run concurrently
 	if ensureFlag {
 		globalGoPath, ok := os.LookupEnv("GOPATH")
 		if !ok {
 			return errors.New("Not found GOPATH")
 		}
 
-		if err = ensure(ctx, globalGoPath, projectRoot, curTarget, false); err != nil {
-			return err
+		for i := range config.Targets {
+			if err = ensure(ctx, globalGoPath, projectRoot, &config.Targets[i], false); err != nil {
+				return err
+			}
 		}
 	}
 
-	var ext string
-	if isWindows {
-		ext = ".exe"
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
 	}
 
-	if find > -1 {
-		if find < len(args)-1 {
-			curTarget.Name = args[find+1]
-		} else {
-			args = append(args[:find], "-o", curTarget.Name+ext)
-		}
-	} else {
-		args = append(args, "-o", curTarget.Name+ext)
-	}
+	var (
+		results   = make([]buildResult, len(config.Targets))
+		sem       = make(chan struct{}, jobs)
+		outputMu  sync.Mutex
+		waitGroup sync.WaitGroup
+	)
 
-	cmd := NewCommand("build").AddArguments(args...)
-	envs := os.Environ()
-	var gopathIdx = -1
-	for i, env := range envs {
-		if strings.HasPrefix(env, "GOPATH=") {
-			gopathIdx = i
-			break
-		}
-	}
+	for i := range config.Targets {
+		// every build owns a copy of the target since -o may change its name
+		target := config.Targets[i]
+		targetArgs := append([]string{}, args...)
 
-	newGopath := fmt.Sprintf("GOPATH=%s", projectRoot)
-	if gopathIdx > 0 {
-		envs[gopathIdx] = newGopath
-	} else {
-		envs = append(envs, newGopath)
+		waitGroup.Add(1)
+		go func(i int, target *Target) {
+			defer waitGroup.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var output bytes.Buffer
+			start := time.Now()
+			_, err := buildTarget(ctx, projectRoot, target, targetArgs, isWindows, false, force, "", &output, &output)
+			results[i] = buildResult{
+				Target:   target.Name,
+				Err:      err,
+				Duration: time.Since(start),
+			}
+
+			outputMu.Lock()
+			writePrefixed(os.Stdout, "["+target.Name+"]", output.Bytes())
+			outputMu.Unlock()
+		}(i, &target)
 	}
-	cmd.Env = envs
+	waitGroup.Wait()
 
-	Println("Building", curTarget.Name)
-	err = cmd.RunInDirPipeline(filepath.Join(projectRoot, "src", curTarget.Dir), os.Stdout, os.Stderr)
-	if err != nil {
-		return err
+	var failed int
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tSTATUS\tTIME")
+	for _, res := range results {
+		var status = "ok"
+		if res.Err != nil {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", res.Target, status, res.Duration-res.Duration%time.Millisecond)
 	}
+	w.Flush()
 
+	if failed > 0 {
+		return fmt.Errorf("%d of %d targets failed to build", failed, len(results))
+	}
 	return nil
 }
 
 func runBuild(ctx *cli.Context) error {
-	args := ctx.Args()
-	var ensureFlagIdx = -1
-	for i, arg := range args {
-		if arg == "-e" {
-			ensureFlagIdx = i
+	var (
+		args       = ctx.Args()
+		buildArgs  = make([]string, 0, len(args))
+		ensureFlag bool
+		forceFlag  bool
+		allFlag    bool
+		jobs       int
+		err        error
+	)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-v":
+			showLog = true
+			buildArgs = append(buildArgs, arg)
+		case arg == "-e":
+			ensureFlag = true
+		case arg == "--force":
+			forceFlag = true
+		case arg == "--all":
+			allFlag = true
+		case arg == "-j" || arg == "--jobs":
+			if i == len(args)-1 {
+				return fmt.Errorf("%s needs a number of jobs", arg)
+			}
+			i++
+			if jobs, err = strconv.Atoi(args[i]); err != nil {
+				return fmt.Errorf("invalid jobs number %s: %v", args[i], err)
+			}
+		case strings.HasPrefix(arg, "--jobs="):
+			if jobs, err = strconv.Atoi(strings.TrimPrefix(arg, "--jobs=")); err != nil {
+				return fmt.Errorf("invalid jobs number %s: %v", arg, err)
+			}
+		default:
+			buildArgs = append(buildArgs, arg)
 		}
 	}
-	if ensureFlagIdx > -1 {
-		args = append(args[:ensureFlagIdx], args[ensureFlagIdx+1:]...)
+
+	isWindows := os.Getenv("GOOS") == "windows" ||
+		(os.Getenv("GOOS") == "" && runtime.GOOS == "windows")
+
+	if allFlag {
+		return runBuildAll(ctx, buildArgs, isWindows, ensureFlag, forceFlag, jobs)
 	}
 
-	return runBuildNoCtx(ctx, args, os.Getenv("GOOS") == "windows" ||
-		(os.Getenv("GOOS") == "" && runtime.GOOS == "windows"), ensureFlagIdx > -1)
+	_, _, err = runBuildNoCtx(ctx, buildArgs, isWindows, ensureFlag, forceFlag)
+	return err
 }
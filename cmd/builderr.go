@@ -0,0 +1,117 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxShownBuildErrors is the number of the errors shown in the summary of a failed build
+const maxShownBuildErrors = 10
+
+// buildErrorRegexp matches the compiler errors like ./main.go:12:3: undefined: foo, the column is optional
+var buildErrorRegexp = regexp.MustCompile(`^(.+?\.(?:go|c|cc|cpp|h|s)):(\d+)(?::(\d+))?: (.+)$`)
+
+// buildError is a compiler error of a source file
+type buildError struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// String returns file:line:column: message, the file is relative to dir if possible
+func (e buildError) String(dir string) string {
+	file := e.File
+	if rel, err := filepath.Rel(dir, file); err == nil && !strings.HasPrefix(rel, "..") {
+		file = rel
+	}
+	if e.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", file, e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", file, e.Line, e.Message)
+}
+
+// parseBuildErrors parses the output of go build which runs in dir, the relative file paths are
+// converted to absolute ones. The indented lines following an error are appended to its message.
+func parseBuildErrors(output []byte, dir string) []buildError {
+	var errs []buildError
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "\t") && len(errs) > 0 {
+			errs[len(errs)-1].Message += "\n" + line
+			continue
+		}
+
+		matches := buildErrorRegexp.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		e := buildError{
+			File:    filepath.FromSlash(matches[1]),
+			Message: matches[4],
+		}
+		if !filepath.IsAbs(e.File) {
+			e.File = filepath.Join(dir, e.File)
+		}
+		e.Line, _ = strconv.Atoi(matches[2])
+		if matches[3] != "" {
+			e.Column, _ = strconv.Atoi(matches[3])
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// buildErrorsPath returns the file the build errors of the target are written to
+func buildErrorsPath(projectRoot string, target *Target) string {
+	return filepath.Join(projectRoot, "bin", target.Name+".errors.json")
+}
+
+// writeBuildErrors writes the errors as a JSON array, an empty array means the last build succeeded
+func writeBuildErrors(p string, errs []buildError) error {
+	if errs == nil {
+		errs = []buildError{}
+	}
+
+	bs, err := json.MarshalIndent(errs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, append(bs, '\n'), 0644)
+}
+
+// printBuildErrors prints a summary of the errors, the whole output is printed if no errors are parsed
+func printBuildErrors(w io.Writer, projectRoot string, errs []buildError, output []byte) {
+	if len(errs) == 0 {
+		w.Write(output)
+		return
+	}
+
+	fmt.Fprintf(w, "=== Build failed with %d errors\n", len(errs))
+	srcDir := filepath.Join(projectRoot, "src")
+	for i, e := range errs {
+		if i == maxShownBuildErrors {
+			fmt.Fprintf(w, "    ... and %d more\n", len(errs)-i)
+			break
+		}
+		fmt.Fprintf(w, "    %s\n", strings.Replace(e.String(srcDir), "\n", "\n    ", -1))
+	}
+}
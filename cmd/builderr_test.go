@@ -0,0 +1,39 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBuildErrors(t *testing.T) {
+	dir := filepath.FromSlash("/project/src/main")
+	output := []byte(`# main
+./main.go:12:3: undefined: foo
+../models/user.go:7: cannot use x (type int) as type string in return argument
+./main.go:20:2: cannot use u (type User) as type Named in assignment:
+	User does not implement Named (missing Name method)
+`)
+
+	errs := parseBuildErrors(output, dir)
+	assert.Len(t, errs, 3)
+	assert.EqualValues(t, buildError{
+		File:    filepath.Join(dir, "main.go"),
+		Line:    12,
+		Column:  3,
+		Message: "undefined: foo",
+	}, errs[0])
+	assert.Equal(t, filepath.FromSlash("/project/src/models/user.go"), errs[1].File)
+	assert.Equal(t, 0, errs[1].Column)
+	assert.Equal(t, "cannot use u (type User) as type Named in assignment:\n\tUser does not implement Named (missing Name method)", errs[2].Message)
+
+	var buf bytes.Buffer
+	printBuildErrors(&buf, filepath.FromSlash("/project"), errs[:1], output)
+	assert.Equal(t, "=== Build failed with 1 errors\n    "+filepath.FromSlash("main/main.go")+":12:3: undefined: foo\n", buf.String())
+}
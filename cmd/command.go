@@ -6,11 +6,15 @@ package cmd
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -108,6 +112,70 @@ func (c *Command) RunInDirTimeoutPipeline(timeout time.Duration, dir string, std
 	return err
 }
 
+// ErrExecCancelled error of executing cancelled
+var ErrExecCancelled = errors.New("cancelled")
+
+// RunInDirCancelPipeline executes the command in given directory until it exits or cancel is closed,
+// it pipes stdout and stderr to given io.Writer. The command and all of its children are killed
+// when it's cancelled. If cancel is nil, the command and its children are stopped when gop receives
+// SIGINT or SIGTERM, since the command runs in its own process group which doesn't receive Ctrl-C.
+func (c *Command) RunInDirCancelPipeline(cancel <-chan struct{}, dir string, stdout, stderr io.Writer) error {
+	cmd := exec.Command(c.name, c.args...)
+	cmd.Dir = dir
+	cmd.Env = c.Env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = newProcessGroupAttr()
+
+	var sigs chan os.Signal
+	if cancel == nil {
+		sigs = make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sigs)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// the process is only waited here, stopProcess is told by exited
+	var waitErr error
+	exited := make(chan struct{})
+	go func() {
+		waitErr = cmd.Wait()
+		close(exited)
+	}()
+
+	if cancel == nil {
+		select {
+		case sig := <-sigs:
+			name := "SIGTERM"
+			if sig == os.Interrupt {
+				name = "SIGINT"
+			}
+			// the whole group is stopped before gop exits, so no children are left running
+			if err := stopProcess(cmd.Process, exited, name, defaultGracePeriod); err != nil {
+				return fmt.Errorf("fail to stop process: %v", err)
+			}
+			<-exited
+			return fmt.Errorf("interrupted by %v", sig)
+		case <-exited:
+			return waitErr
+		}
+	}
+
+	select {
+	case <-cancel:
+		if err := stopProcess(cmd.Process, exited, "SIGKILL", time.Second); err != nil {
+			return fmt.Errorf("fail to kill process: %v", err)
+		}
+		<-exited
+		return ErrExecCancelled
+	case <-exited:
+		return waitErr
+	}
+}
+
 // RunInDirTimeout executes the command in given directory with given timeout,
 // and returns stdout in []byte and error (combined with stderr).
 func (c *Command) RunInDirTimeout(timeout time.Duration, dir string) ([]byte, error) {
@@ -40,6 +40,12 @@ var CmdConfig = cli.Command{
 			Description: `Set global config options`,
 			Action:      runConfigSet,
 		},
+		{
+			Name:        "validate",
+			Usage:       "Validate gop.yml of the project",
+			Description: `Check the syntax, the unknown keys, the targets and the groups of gop.yml`,
+			Action:      runConfigValidate,
+		},
 	},
 	Flags: []cli.Flag{
 		cli.BoolFlag{
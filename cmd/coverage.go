@@ -0,0 +1,231 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// coverProfile is the merged coverage profiles of the packages, the blocks are keyed by
+// file:startLine.startCol,endLine.endCol
+type coverProfile struct {
+	mode   string
+	blocks map[string]*coverBlock
+}
+
+type coverBlock struct {
+	statements int
+	count      int
+}
+
+func newCoverProfile() *coverProfile {
+	return &coverProfile{
+		blocks: make(map[string]*coverBlock),
+	}
+}
+
+// merge adds the blocks of a profile written by go test -coverprofile. The same blocks of the different
+// test binaries are counted together in count or atomic mode, and covered if any covers them in set mode.
+func (p *coverProfile) merge(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "mode: ") {
+			mode := strings.TrimPrefix(line, "mode: ")
+			if p.mode != "" && p.mode != mode {
+				return fmt.Errorf("cannot merge the coverage profiles of mode %s and %s", p.mode, mode)
+			}
+			p.mode = mode
+			continue
+		}
+
+		// file:1.2,3.4 statements count
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return fmt.Errorf("bad coverage profile line: %s", line)
+		}
+		statements, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("bad coverage profile line: %s", line)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf("bad coverage profile line: %s", line)
+		}
+
+		block, ok := p.blocks[fields[0]]
+		if !ok {
+			p.blocks[fields[0]] = &coverBlock{statements: statements, count: count}
+			continue
+		}
+		if p.mode == "set" {
+			if count > block.count {
+				block.count = count
+			}
+		} else {
+			block.count += count
+		}
+	}
+	return scanner.Err()
+}
+
+// WriteTo writes the profile in the format of go test -coverprofile
+func (p *coverProfile) WriteTo(w io.Writer) (int64, error) {
+	var keys = make([]string, 0, len(p.blocks))
+	for key := range p.blocks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	mode := p.mode
+	if mode == "" {
+		mode = "set"
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "mode: %s\n", mode)
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%s %d %d\n", key, p.blocks[key].statements, p.blocks[key].count)
+	}
+	return buf.WriteTo(w)
+}
+
+// packageCoverage is the statements of a package and how many of them are covered
+type packageCoverage struct {
+	Package    string
+	Statements int
+	Covered    int
+}
+
+// Percent returns the percentage of the covered statements, a package without statements is fully covered
+func (c packageCoverage) Percent() float64 {
+	if c.Statements == 0 {
+		return 100
+	}
+	return float64(c.Covered) * 100 / float64(c.Statements)
+}
+
+// packages returns the coverages of the packages sorted by the package, and the total coverage
+func (p *coverProfile) packages() ([]packageCoverage, packageCoverage) {
+	var pkgs = make(map[string]*packageCoverage)
+	var total packageCoverage
+	for key, block := range p.blocks {
+		file := key[:strings.LastIndex(key, ":")]
+		pkg := path.Dir(file)
+		c, ok := pkgs[pkg]
+		if !ok {
+			c = &packageCoverage{Package: pkg}
+			pkgs[pkg] = c
+		}
+		c.Statements += block.statements
+		total.Statements += block.statements
+		if block.count > 0 {
+			c.Covered += block.statements
+			total.Covered += block.statements
+		}
+	}
+
+	var coverages = make([]packageCoverage, 0, len(pkgs))
+	for _, c := range pkgs {
+		coverages = append(coverages, *c)
+	}
+	sort.Slice(coverages, func(i, j int) bool {
+		return coverages[i].Package < coverages[j].Package
+	})
+	return coverages, total
+}
+
+// printCoverage prints the coverages as a table followed by the total
+func printCoverage(w io.Writer, coverages []packageCoverage, total packageCoverage) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tSTATEMENTS\tCOVERAGE")
+	for _, c := range coverages {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\n", c.Package, c.Statements, c.Percent())
+	}
+	fmt.Fprintf(tw, "total\t%d\t%.1f%%\n", total.Statements, total.Percent())
+	tw.Flush()
+}
+
+// coverageProfilePath returns the file the merged coverage profile is written to
+func coverageProfilePath(projectRoot string) string {
+	return filepath.Join(projectRoot, "bin", "coverage.out")
+}
+
+// runCoverTests runs go test for every package with the coverage of the pkgs in its test binary, since
+// go test cannot write the coverage profile of many packages at once. The profiles are merged into
+// bin/coverage.out and an HTML report bin/coverage.html is generated.
+func runCoverTests(projectRoot string, graph *packageGraph, args, pkgs, envs []string, stdout io.Writer) ([]byte, *coverProfile, error) {
+	tmpDir, err := ioutil.TempDir("", "gop-cover")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var (
+		output  []byte
+		testErr error
+		profile = newCoverProfile()
+	)
+	for i, pkg := range pkgs {
+		// only the vendored packages are left out of the coverage
+		coverPkgs := intersectPackages(graph.testDependencies(filepath.Join(graph.srcDir, filepath.FromSlash(pkg))), pkgs)
+		profilePath := filepath.Join(tmpDir, fmt.Sprintf("%d.out", i))
+		coverArgs := append([]string{
+			"-coverprofile=" + profilePath,
+			"-coverpkg=" + strings.Join(coverPkgs, ","),
+		}, args...)
+		out, err := runTestPackages(projectRoot, coverArgs, []string{pkg}, envs, stdout, nil)
+		output = append(output, out...)
+		if err != nil {
+			testErr = err
+		}
+
+		// the packages without test files have no profile
+		data, err := ioutil.ReadFile(profilePath)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return output, nil, err
+		}
+		if err = profile.merge(data); err != nil {
+			return output, nil, err
+		}
+	}
+
+	outPath := coverageProfilePath(projectRoot)
+	if err = os.MkdirAll(filepath.Dir(outPath), os.ModePerm); err != nil {
+		return output, nil, err
+	}
+	f, err := os.Create(outPath)
+	if err != nil {
+		return output, nil, err
+	}
+	_, err = profile.WriteTo(f)
+	f.Close()
+	if err != nil {
+		return output, nil, err
+	}
+
+	htmlPath := strings.TrimSuffix(outPath, ".out") + ".html"
+	cmd := NewCommand("tool", "cover", "-html="+outPath, "-o", htmlPath)
+	cmd.Env = envs
+	if _, err = cmd.RunInDir(filepath.Join(projectRoot, "src")); err != nil {
+		return output, nil, err
+	}
+	return output, profile, testErr
+}
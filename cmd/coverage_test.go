@@ -0,0 +1,43 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoverProfile(t *testing.T) {
+	profile := newCoverProfile()
+	assert.NoError(t, profile.merge([]byte(`mode: set
+models/user.go:10.2,12.3 2 1
+models/user.go:14.2,16.3 3 0
+routes/api.go:5.1,7.2 1 0
+`)))
+	assert.NoError(t, profile.merge([]byte(`mode: set
+models/user.go:10.2,12.3 2 0
+models/user.go:14.2,16.3 3 1
+routes/api.go:5.1,7.2 1 0
+`)))
+	assert.Error(t, profile.merge([]byte("mode: count\n")))
+
+	coverages, total := profile.packages()
+	assert.EqualValues(t, []packageCoverage{
+		{Package: "models", Statements: 5, Covered: 5},
+		{Package: "routes", Statements: 1, Covered: 0},
+	}, coverages)
+	assert.EqualValues(t, packageCoverage{Statements: 6, Covered: 5}, total)
+
+	var buf bytes.Buffer
+	_, err := profile.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.EqualValues(t, `mode: set
+models/user.go:10.2,12.3 2 1
+models/user.go:14.2,16.3 3 1
+routes/api.go:5.1,7.2 1 0
+`, buf.String())
+}
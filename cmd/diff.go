@@ -0,0 +1,168 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContext = 3
+	// maxDiffCells limits the memory of the diff, the larger changes are shown as replacing all the lines
+	maxDiffCells = 16 * 1024 * 1024
+)
+
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// splitLines splits s into the lines with the line endings
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// hunkRange returns the range of a hunk, the start of an empty range is the line before it
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// diffLines returns the lines of a and b marked by ' ', '-' or '+' based on the longest common subsequence
+func diffLines(a, b []string) []diffLine {
+	// the common prefix and suffix are kept out of the table
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []diffLine
+	for _, line := range a[:prefix] {
+		lines = append(lines, diffLine{' ', line})
+	}
+
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(ma)+1)*(len(mb)+1) > maxDiffCells {
+		for _, line := range ma {
+			lines = append(lines, diffLine{'-', line})
+		}
+		for _, line := range mb {
+			lines = append(lines, diffLine{'+', line})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of ma[i:] and mb[j:]
+		lcs := make([][]int, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+
+		var i, j int
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				lines = append(lines, diffLine{' ', ma[i]})
+				i++
+				j++
+			case j >= len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+				lines = append(lines, diffLine{'-', ma[i]})
+				i++
+			default:
+				lines = append(lines, diffLine{'+', mb[j]})
+				j++
+			}
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	return lines
+}
+
+// unifiedDiff returns the changes from a to b in the unified format, it's empty if there are no changes
+func unifiedDiff(name string, a, b []byte) string {
+	lines := diffLines(splitLines(string(a)), splitLines(string(b)))
+
+	var buf bytes.Buffer
+	// the line numbers of a and b before every line
+	var numA, numB = make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for i, line := range lines {
+		numA[i+1], numB[i+1] = numA[i], numB[i]
+		if line.op != '+' {
+			numA[i+1]++
+		}
+		if line.op != '-' {
+			numB[i+1]++
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+
+		// a hunk ends when there are more than 2 * diffContext unchanged lines
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(lines) {
+			if lines[end].op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].op == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+		end += diffContext
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- a/%s\n+++ b/%s\n", name, name)
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(numA[start], numA[end]-numA[start]), hunkRange(numB[start], numB[end]-numB[start]))
+		for _, line := range lines[start:end] {
+			buf.WriteByte(line.op)
+			buf.WriteString(line.text)
+			if !strings.HasSuffix(line.text, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return buf.String()
+}
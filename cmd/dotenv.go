@@ -0,0 +1,69 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// parseDotEnv parses the KEY=VALUE lines of a .env file, blank lines and lines starting
+// with # are ignored, values could be quoted and the line could have an export prefix.
+func parseDotEnv(r io.Reader) ([]string, error) {
+	var envs []string
+	scanner := bufio.NewScanner(r)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNum)
+		}
+		key := strings.TrimSpace(kv[0])
+		if key == "" {
+			return nil, fmt.Errorf("line %d: empty key", lineNum)
+		}
+
+		value := strings.TrimSpace(kv[1])
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			value = value[1 : len(value)-1]
+			value = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(value)
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		default:
+			// remove the inline comment of an unquoted value
+			if i := strings.Index(value, " #"); i > -1 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		envs = append(envs, key+"="+value)
+	}
+	return envs, scanner.Err()
+}
+
+// loadDotEnv loads the environment variables from a .env file
+func loadDotEnv(p string) ([]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	envs, err := parseDotEnv(f)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s failed: %v", p, err)
+	}
+	return envs, nil
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDotEnv(t *testing.T) {
+	envs, err := parseDotEnv(strings.NewReader(`
+# comment
+PORT=8000
+export MODE=dev
+NAME="hello world"
+RAW='a\nb'
+ESCAPED="a\nb"
+URL=http://localhost # the url
+EMPTY=
+`))
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{
+		"PORT=8000",
+		"MODE=dev",
+		"NAME=hello world",
+		`RAW=a\nb`,
+		"ESCAPED=a\nb",
+		"URL=http://localhost",
+		"EMPTY=",
+	}, envs)
+
+	_, err = parseDotEnv(strings.NewReader("INVALID"))
+	assert.Error(t, err)
+}
@@ -189,9 +189,10 @@ func runEnsure(ctx *cli.Context) error {
 		targetName = args[0]
 	}
 
-	if err = analysisTarget(level, targetName, projectRoot); err != nil {
+	target, err := analysisTarget(level, targetName, projectRoot)
+	if err != nil {
 		return err
 	}
 
-	return ensure(ctx, globalGoPath, projectRoot, curTarget, ctx.Bool("test"))
+	return ensure(ctx, globalGoPath, projectRoot, target, ctx.Bool("test"))
 }
@@ -0,0 +1,215 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/urfave/cli"
+)
+
+// CmdEnv represents the env command
+var CmdEnv = cli.Command{
+	Name:            "env",
+	Usage:           "Print the project environment",
+	Description:     `Print the environment variables set by gop for the project as shell export lines, or JSON with --json`,
+	Action:          runEnv,
+	SkipFlagParsing: true,
+}
+
+// CmdExec represents the exec command
+var CmdExec = cli.Command{
+	Name:            "exec",
+	Usage:           "Run a command with the project environment",
+	Description:     `Run any command with the project environment, i.e. gop exec -- dlv debug`,
+	Action:          runExec,
+	SkipFlagParsing: true,
+}
+
+// envVar is an environment variable set by gop for the project
+type envVar struct {
+	Name  string
+	Value string
+}
+
+// projectVars returns the environment variables set for the commands run in the project,
+// GOPATH is the project root and the tools built by gop generate are put on PATH.
+// If the project lives inside the global GOPATH, the global one follows the project root,
+// so the project packages and its vendor are always in front.
+func projectVars(projectRoot string) []envVar {
+	gopath := projectRoot
+	if _, ok := projectInGoPath(projectRoot); ok {
+		gopath += string(os.PathListSeparator) + globalGoPath()
+	}
+	var vars = []envVar{
+		{"GOPATH", gopath},
+	}
+
+	toolsDir := filepath.Join(projectRoot, "bin", "tools")
+	if IsDir(toolsDir) {
+		path := toolsDir
+		if old := os.Getenv("PATH"); old != "" {
+			path += string(os.PathListSeparator) + old
+		}
+		vars = append(vars, envVar{"PATH", path})
+	}
+	return vars
+}
+
+// projectEnv returns the current environment with the project variables
+func projectEnv(projectRoot string) []string {
+	envs := os.Environ()
+	for _, v := range projectVars(projectRoot) {
+		envs = setEnv(envs, v.Name, v.Value)
+	}
+	return envs
+}
+
+// setEnv returns envs with the variable set to value, the names are case insensitive on windows
+func setEnv(envs []string, name, value string) []string {
+	var result = make([]string, 0, len(envs)+1)
+	for _, env := range envs {
+		k := strings.SplitN(env, "=", 2)[0]
+		if k == name || (runtime.GOOS == "windows" && strings.EqualFold(k, name)) {
+			continue
+		}
+		result = append(result, env)
+	}
+	return append(result, name+"="+value)
+}
+
+// getEnv returns the value of the variable in envs like exec.Cmd, the last one wins.
+// The environment of gop is used if envs is nil.
+func getEnv(envs []string, name string) string {
+	if envs == nil {
+		return os.Getenv(name)
+	}
+
+	var value string
+	for _, env := range envs {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) == 2 && (kv[0] == name || (runtime.GOOS == "windows" && strings.EqualFold(kv[0], name))) {
+			value = kv[1]
+		}
+	}
+	return value
+}
+
+// shellQuote quotes s with single quotes for the POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func runEnv(ctx *cli.Context) error {
+	var jsonFlag bool
+	for _, arg := range ctx.Args() {
+		switch arg {
+		case "--json":
+			jsonFlag = true
+		case "-v":
+			showLog = true
+		default:
+			return fmt.Errorf("unknown argument %s", arg)
+		}
+	}
+
+	_, projectRoot, reason, err := locateProject()
+	if err != nil {
+		return err
+	}
+
+	vars := projectVars(projectRoot)
+	if !jsonFlag {
+		// the comment explains which project is chosen, it's ignored by eval
+		fmt.Println("#", reason)
+		for _, v := range vars {
+			fmt.Printf("export %s=%s\n", v.Name, shellQuote(v.Value))
+		}
+		return nil
+	}
+
+	var m = make(map[string]string, len(vars))
+	for _, v := range vars {
+		m[v.Name] = v.Value
+	}
+	bs, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(bs))
+	return nil
+}
+
+func runExec(ctx *cli.Context) error {
+	var args = []string(ctx.Args())
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return errors.New("no command to run, usage: gop exec -- <cmd> [args]")
+	}
+
+	_, projectRoot, err := analysisDirLevel()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = projectEnv(projectRoot)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Ctrl-C is received by the command from the terminal, i.e. dlv pauses the program with it,
+	// so gop must not exit on it. The other signals are relayed to the command.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+wait:
+	for {
+		select {
+		case sig := <-sigs:
+			if sig != os.Interrupt {
+				cmd.Process.Signal(sig)
+			}
+		case err = <-done:
+			break wait
+		}
+	}
+
+	return exitStatusError(err)
+}
+
+// exitStatusError converts the exit error of a command to the error of gop with the same exit code,
+// it's 128+signal if the command is killed by a signal like the shells. The other errors are returned as is.
+func exitStatusError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return cli.NewExitError("", 128+int(status.Signal()))
+			}
+			return cli.NewExitError("", status.ExitStatus())
+		}
+	}
+	return err
+}
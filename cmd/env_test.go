@@ -0,0 +1,61 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+func TestProjectEnv(t *testing.T) {
+	envs := setEnv([]string{"GOPATH=/go", "HOME=/root"}, "GOPATH", "/project")
+	assert.EqualValues(t, []string{"HOME=/root", "GOPATH=/project"}, envs)
+	// the first variable is replaced too
+	envs = setEnv([]string{"GOPATH=/go"}, "GOPATH", "/project")
+	assert.EqualValues(t, []string{"GOPATH=/project"}, envs)
+
+	assert.EqualValues(t, `'it'\''s'`, shellQuote("it's"))
+
+	projectRoot, err := ioutil.TempDir("", "gop-env")
+	assert.NoError(t, err)
+	defer os.RemoveAll(projectRoot)
+
+	assert.EqualValues(t, []envVar{{"GOPATH", projectRoot}}, projectVars(projectRoot))
+
+	toolsDir := filepath.Join(projectRoot, "bin", "tools")
+	assert.NoError(t, os.MkdirAll(toolsDir, os.ModePerm))
+	vars := projectVars(projectRoot)
+	assert.Len(t, vars, 2)
+	assert.EqualValues(t, "PATH", vars[1].Name)
+	assert.EqualValues(t, toolsDir+string(os.PathListSeparator)+os.Getenv("PATH"), vars[1].Value)
+}
+
+func TestExitStatusError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh is needed")
+	}
+
+	// go test exits with 2 for the bad flags
+	err := exitStatusError(exec.Command("sh", "-c", "exit 2").Run())
+	if assert.Implements(t, (*cli.ExitCoder)(nil), err) {
+		assert.EqualValues(t, 2, err.(cli.ExitCoder).ExitCode())
+	}
+
+	err = exitStatusError(exec.Command("sh", "-c", "kill -TERM $$").Run())
+	if assert.Implements(t, (*cli.ExitCoder)(nil), err) {
+		assert.EqualValues(t, 143, err.(cli.ExitCoder).ExitCode())
+	}
+
+	other := errors.New("no packages to test")
+	assert.Equal(t, other, exitStatusError(other))
+}
@@ -0,0 +1,193 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// sourceExts are the file extensions go build will read from a package directory
+var sourceExts = map[string]bool{
+	".go":      true,
+	".c":       true,
+	".cc":      true,
+	".cpp":     true,
+	".cxx":     true,
+	".h":       true,
+	".hh":      true,
+	".hpp":     true,
+	".hxx":     true,
+	".m":       true,
+	".s":       true,
+	".S":       true,
+	".f":       true,
+	".F":       true,
+	".for":     true,
+	".f90":     true,
+	".syso":    true,
+	".swig":    true,
+	".swigcxx": true,
+}
+
+// fingerprintEnvs are the environment variables which affect the output of go build
+var fingerprintEnvs = []string{"GOOS", "GOARCH", "GOARM", "CGO_ENABLED", "CC", "CGO_CFLAGS", "CGO_LDFLAGS"}
+
+// buildTags returns the value of -tags in go build arguments
+func buildTags(args []string) string {
+	for i, arg := range args {
+		if arg == "-tags" && i < len(args)-1 {
+			return args[i+1]
+		} else if strings.HasPrefix(arg, "-tags=") {
+			return strings.TrimPrefix(arg, "-tags=")
+		}
+	}
+	return ""
+}
+
+// fingerprintPath returns the file path the fingerprint of the binary will be stored
+func fingerprintPath(exePath string) string {
+	return filepath.Join(filepath.Dir(exePath), "."+filepath.Base(exePath)+".fingerprint")
+}
+
+// hashPkgDir writes all the source files' names and contents of the package directory to h
+func hashPkgDir(h io.Writer, projectRoot, dir string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range fis {
+		if fi.IsDir() || !sourceExts[filepath.Ext(fi.Name())] || strings.HasSuffix(fi.Name(), "_test.go") {
+			continue
+		}
+
+		p := filepath.Join(dir, fi.Name())
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+
+		relPath, _ := filepath.Rel(projectRoot, p)
+		fmt.Fprintf(h, "%s\x00", filepath.ToSlash(relPath))
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(h, "\x00")
+	}
+	return nil
+}
+
+// envBuildContext returns the build context of go build with the environment variables and the tags
+func envBuildContext(envs []string, tags string) build.Context {
+	ctxt := build.Default
+	ctxt.BuildTags = strings.Split(tags, " ")
+	if goos := getEnv(envs, "GOOS"); goos != "" {
+		ctxt.GOOS = goos
+	}
+	if goarch := getEnv(envs, "GOARCH"); goarch != "" {
+		ctxt.GOARCH = goarch
+	}
+	switch getEnv(envs, "CGO_ENABLED") {
+	case "0":
+		ctxt.CgoEnabled = false
+	case "1":
+		ctxt.CgoEnabled = true
+	default:
+		// go build disables cgo by default when cross compiling
+		if ctxt.GOOS != runtime.GOOS || ctxt.GOARCH != runtime.GOARCH {
+			ctxt.CgoEnabled = false
+		}
+	}
+	return ctxt
+}
+
+// targetPackageDirs returns the sorted directories of the target and all of its project and vendored dependencies,
+// the files are selected by the build environment envs
+func targetPackageDirs(projectRoot string, target *Target, args, envs []string) ([]string, error) {
+	imports, err := ListImportsContext(envBuildContext(envs, buildTags(args)), projectRoot, target.Dir, projectRoot,
+		filepath.Join(projectRoot, "src"), false)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs = map[string]bool{
+		filepath.Join(projectRoot, "src", target.Dir): true,
+	}
+	for _, imp := range imports {
+		switch imp.Type {
+		case PkgTypeProjectGoPath:
+			dirs[filepath.Join(projectRoot, "src", imp.Name)] = true
+		case PkgTypeProjectVendor:
+			dirs[filepath.Join(projectRoot, "src", "vendor", imp.Name)] = true
+		}
+	}
+
+	var sortedDirs = make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sortedDirs = append(sortedDirs, dir)
+	}
+	sort.Strings(sortedDirs)
+	return sortedDirs, nil
+}
+
+// targetFingerprint computes a hash of everything which affects the binary of the target:
+// the source files of the target and all of its project and vendored dependencies,
+// the build arguments, the go version and the build environment variables.
+func targetFingerprint(projectRoot string, target *Target, args, envs []string) (string, error) {
+	sortedDirs, err := targetPackageDirs(projectRoot, target, args, envs)
+	if err != nil {
+		return "", err
+	}
+
+	goVersion, err := retrieveGoVersion()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "go:%s\x00", goVersion)
+	fmt.Fprintf(h, "args:%s\x00", strings.Join(args, "\x01"))
+	for _, env := range fingerprintEnvs {
+		fmt.Fprintf(h, "%s=%s\x00", env, getEnv(envs, env))
+	}
+	for _, dir := range sortedDirs {
+		if err = hashPkgDir(h, projectRoot, dir); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// isUpToDate returns true if the binary exists and it's stored fingerprint is the same as fingerprint
+func isUpToDate(exePath, fingerprint string) bool {
+	exist, _ := isFileExist(exePath)
+	if !exist {
+		return false
+	}
+
+	bs, err := ioutil.ReadFile(fingerprintPath(exePath))
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(bs)) == fingerprint
+}
+
+// saveFingerprint stores the fingerprint next to the binary
+func saveFingerprint(exePath, fingerprint string) error {
+	return ioutil.WriteFile(fingerprintPath(exePath), []byte(fingerprint+"\n"), 0644)
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, rootPath, relPath, content string) {
+	p := filepath.Join(rootPath, filepath.FromSlash(relPath))
+	assert.NoError(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+}
+
+func TestTargetFingerprint(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), fmt.Sprintf("%d", time.Now().UnixNano()))
+	defer os.RemoveAll(tmpDir)
+
+	writeFile(t, tmpDir, "gop.yml", "targets:\n- name: app\n  dir: main\n")
+	writeFile(t, tmpDir, "src/main/main.go", "package main\n\nimport \"models\"\n\nfunc main() { models.Do() }\n")
+	writeFile(t, tmpDir, "src/models/models.go", "package models\n\nfunc Do() {}\n")
+	writeFile(t, tmpDir, "src/other/other.go", "package other\n")
+
+	target := &Target{Name: "app", Dir: "main"}
+	fp1, err := targetFingerprint(tmpDir, target, nil, nil)
+	assert.NoError(t, err)
+
+	// an unrelated package doesn't change the fingerprint
+	writeFile(t, tmpDir, "src/other/other.go", "package other\n\nfunc A() {}\n")
+	fp2, err := targetFingerprint(tmpDir, target, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, fp1, fp2)
+
+	// test files don't change the fingerprint
+	writeFile(t, tmpDir, "src/models/models_test.go", "package models\n")
+	fp2, err = targetFingerprint(tmpDir, target, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, fp1, fp2)
+
+	// a dependent package changes the fingerprint
+	writeFile(t, tmpDir, "src/models/models.go", "package models\n\nfunc Do() { println() }\n")
+	fp3, err := targetFingerprint(tmpDir, target, nil, nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, fp1, fp3)
+
+	// build flags change the fingerprint
+	fp4, err := targetFingerprint(tmpDir, target, []string{"-race"}, nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, fp3, fp4)
+
+	exePath := filepath.Join(tmpDir, "src", "main", "app")
+	assert.False(t, isUpToDate(exePath, fp3))
+	writeFile(t, tmpDir, "src/main/app", "binary")
+	assert.NoError(t, saveFingerprint(exePath, fp3))
+	assert.True(t, isUpToDate(exePath, fp3))
+	assert.False(t, isUpToDate(exePath, fp4))
+
+	// the packages imported by the files of the target platform are fingerprinted when cross compiling
+	goos := "linux"
+	if runtime.GOOS == "linux" {
+		goos = "windows"
+	}
+	writeFile(t, tmpDir, "src/main/main_"+goos+".go", "package main\n\nimport _ \"platform\"\n")
+	writeFile(t, tmpDir, "src/platform/platform.go", "package platform\n")
+	envs := []string{"GOOS=" + goos, "CGO_ENABLED=0"}
+	dirs, err := targetPackageDirs(tmpDir, target, nil, envs)
+	assert.NoError(t, err)
+	assert.Contains(t, dirs, filepath.Join(tmpDir, "src", "platform"))
+	dirs, err = targetPackageDirs(tmpDir, target, nil, []string{"GOOS=" + runtime.GOOS})
+	assert.NoError(t, err)
+	assert.NotContains(t, dirs, filepath.Join(tmpDir, "src", "platform"))
+
+	fp5, err := targetFingerprint(tmpDir, target, nil, envs)
+	assert.NoError(t, err)
+	writeFile(t, tmpDir, "src/platform/platform.go", "package platform\n\nfunc A() {}\n")
+	fp6, err := targetFingerprint(tmpDir, target, nil, envs)
+	assert.NoError(t, err)
+	assert.NotEqual(t, fp5, fp6)
+}
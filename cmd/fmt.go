@@ -0,0 +1,263 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// CmdFmt represents the fmt command
+var CmdFmt = cli.Command{
+	Name:            "fmt",
+	Usage:           "Format the project sources except vendor",
+	Description:     `Format all the go files under src except vendor with the simplifications of gofmt -s`,
+	Action:          runFmt,
+	SkipFlagParsing: true,
+}
+
+// projectGoFiles returns the go files under src, the vendor and testdata directories
+// and the directories beginning with . or _ are skipped like the go tool
+func projectGoFiles(projectRoot string) ([]string, error) {
+	var files []string
+	srcDir := filepath.Join(projectRoot, "src")
+	err := filepath.Walk(srcDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if p != srcDir && (name == "vendor" || name == "testdata" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(name, ".go") && !strings.HasPrefix(name, ".") {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// sourceFormatter formats the go files like gofmt -s, the imports could also be grouped
+// into the standard packages and the others like goimports
+type sourceFormatter struct {
+	ctxt         build.Context
+	groupImports bool
+	std          map[string]bool
+}
+
+func newSourceFormatter(projectRoot string, groupImports bool) *sourceFormatter {
+	ctxt := build.Default
+	ctxt.GOPATH = projectRoot
+	return &sourceFormatter{
+		ctxt:         ctxt,
+		groupImports: groupImports,
+		std:          make(map[string]bool),
+	}
+}
+
+// format returns the formatted source of the file
+func (f *sourceFormatter) format(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	ast.Walk(simplifier{}, file)
+
+	var buf bytes.Buffer
+	if err = format.Node(&buf, fset, file); err != nil {
+		return nil, err
+	}
+	if !f.groupImports {
+		return buf.Bytes(), nil
+	}
+	return f.regroupImports(buf.Bytes())
+}
+
+// isStd returns true if the import path is a package of the standard library
+func (f *sourceFormatter) isStd(path string) bool {
+	if path == "C" {
+		return true
+	}
+	std, ok := f.std[path]
+	if !ok {
+		pkg, err := f.ctxt.Import(path, "", build.FindOnly)
+		std = err == nil && pkg.Goroot
+		f.std[path] = std
+	}
+	return std
+}
+
+// regroupImports puts the standard packages before the others in every import block, separated by
+// a blank line. The blocks with comments other than the line comments of the imports are kept.
+func (f *sourceFormatter) regroupImports(src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	// the blocks are replaced from the last one to keep the offsets of the others
+	for i := len(file.Decls) - 1; i >= 0; i-- {
+		decl, ok := file.Decls[i].(*ast.GenDecl)
+		if !ok || decl.Tok != token.IMPORT || !decl.Lparen.IsValid() {
+			continue
+		}
+		block, ok := f.importBlock(fset, file, decl, src)
+		if !ok {
+			continue
+		}
+
+		start, end := fset.Position(decl.Pos()).Offset, fset.Position(decl.End()).Offset
+		var out = make([]byte, 0, len(src)+len(block))
+		out = append(out, src[:start]...)
+		out = append(out, block...)
+		src = append(out, src[end:]...)
+	}
+	return format.Source(src)
+}
+
+func (f *sourceFormatter) importBlock(fset *token.FileSet, file *ast.File, decl *ast.GenDecl, src []byte) (string, bool) {
+	var lineComments = make(map[*ast.CommentGroup]bool)
+	for _, spec := range decl.Specs {
+		if c := spec.(*ast.ImportSpec).Comment; c != nil {
+			lineComments[c] = true
+		}
+	}
+	for _, c := range file.Comments {
+		if c.Pos() > decl.Lparen && c.End() < decl.Rparen && !lineComments[c] {
+			return "", false
+		}
+	}
+
+	type importLine struct {
+		path string
+		text string
+	}
+	var groups [2][]importLine
+	for _, s := range decl.Specs {
+		spec := s.(*ast.ImportSpec)
+		path, _ := strconv.Unquote(spec.Path.Value)
+		end := spec.End()
+		if spec.Comment != nil {
+			end = spec.Comment.End()
+		}
+
+		line := importLine{
+			path: path,
+			text: string(src[fset.Position(spec.Pos()).Offset:fset.Position(end).Offset]),
+		}
+		if f.isStd(path) {
+			groups[0] = append(groups[0], line)
+		} else {
+			groups[1] = append(groups[1], line)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("import (\n")
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		if i > 0 && len(groups[0]) > 0 {
+			buf.WriteString("\n")
+		}
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].path < group[j].path
+		})
+		for _, line := range group {
+			fmt.Fprintf(&buf, "\t%s\n", line.text)
+		}
+	}
+	buf.WriteString(")")
+	return buf.String(), true
+}
+
+func runFmt(ctx *cli.Context) error {
+	var checkFlag, importsFlag bool
+	for _, arg := range ctx.Args() {
+		switch arg {
+		case "--check":
+			checkFlag = true
+		case "--imports":
+			importsFlag = true
+		case "-v":
+			showLog = true
+		default:
+			return fmt.Errorf("unknown argument %s", arg)
+		}
+	}
+
+	_, projectRoot, err := analysisDirLevel()
+	if err != nil {
+		return err
+	}
+
+	files, err := projectGoFiles(projectRoot)
+	if err != nil {
+		return err
+	}
+
+	formatter := newSourceFormatter(projectRoot, importsFlag)
+	var unformatted, failed int
+	for _, file := range files {
+		Println("Formatting", file)
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		res, err := formatter.format(file, src)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed++
+			continue
+		}
+		if bytes.Equal(src, res) {
+			continue
+		}
+
+		unformatted++
+		rel, _ := filepath.Rel(projectRoot, file)
+		fmt.Println(rel)
+		if checkFlag {
+			fmt.Print(unifiedDiff(filepath.ToSlash(rel), src, res))
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(file, res, info.Mode()); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d files cannot be formatted", failed)
+	}
+	if checkFlag && unformatted > 0 {
+		return fmt.Errorf("%d files are not formatted, please run gop fmt", unformatted)
+	}
+	return nil
+}
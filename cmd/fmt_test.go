@@ -0,0 +1,90 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatSource(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gop-fmt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	src := []byte(`package main
+import (
+	"models"
+	"fmt" // print
+	"os"
+)
+type point struct{ x, y int }
+func main() {
+	s := []int{1, 2}
+	s = s[1:len(s)]
+	for i, _ := range s {
+		fmt.Println(i)
+	}
+	for _ = range s {
+	}
+	_ = []point{point{1, 2}}
+	_ = map[string]*point{"a": &point{1, 2}}
+	_ = os.Args
+	_ = models.Name
+}
+`)
+
+	res, err := newSourceFormatter(tmpDir, true).format("main.go", src)
+	assert.NoError(t, err)
+	assert.EqualValues(t, `package main
+
+import (
+	"fmt" // print
+	"os"
+
+	"models"
+)
+
+type point struct{ x, y int }
+
+func main() {
+	s := []int{1, 2}
+	s = s[1:]
+	for i := range s {
+		fmt.Println(i)
+	}
+	for range s {
+	}
+	_ = []point{{1, 2}}
+	_ = map[string]*point{"a": {1, 2}}
+	_ = os.Args
+	_ = models.Name
+}
+`, string(res))
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	assert.EqualValues(t, "", unifiedDiff("a.go", []byte("a\nb\n"), []byte("a\nb\n")))
+	assert.EqualValues(t, `--- a/a.go
++++ b/a.go
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+`, unifiedDiff("a.go", []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n"), []byte("1\n2\n3\n4\nfive\n6\n7\n8\n9\n")))
+	assert.EqualValues(t, `--- a/a.go
++++ b/a.go
+@@ -0,0 +1,1 @@
++a
+`, unifiedDiff("a.go", nil, []byte("a\n")))
+}
@@ -0,0 +1,286 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// CmdGenerate represents the generate command
+var CmdGenerate = cli.Command{
+	Name:            "generate",
+	Usage:           "Run go generate with the project GOPATH",
+	Description:     `Run go generate on the project packages with the project GOPATH, the tools under src/tools and the vendored commands run by //go:generate are built and put on PATH`,
+	Action:          runGenerate,
+	SkipFlagParsing: true,
+}
+
+// snapshotFiles returns the content hashes of the files under src except vendor
+func snapshotFiles(projectRoot string) (map[string][sha1.Size]byte, error) {
+	var files = make(map[string][sha1.Size]byte)
+	srcDir := filepath.Join(projectRoot, "src")
+	err := filepath.Walk(srcDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p == filepath.Join(srcDir, "vendor") || (p != srcDir && strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		bs, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[p] = sha1.Sum(bs)
+		return nil
+	})
+	return files, err
+}
+
+// changedFiles returns the added (A), modified (M) and deleted (D) files from before to after
+func changedFiles(before, after map[string][sha1.Size]byte) []string {
+	var changes []string
+	for p, sum := range after {
+		old, ok := before[p]
+		if !ok {
+			changes = append(changes, "A "+p)
+		} else if old != sum {
+			changes = append(changes, "M "+p)
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; !ok {
+			changes = append(changes, "D "+p)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i][2:] < changes[j][2:]
+	})
+	return changes
+}
+
+// generateCommands returns the names of the commands run by the //go:generate directives of the packages
+func generateCommands(srcDir string, pkgs []string) (map[string]bool, error) {
+	var names = make(map[string]bool)
+	for _, pkg := range pkgs {
+		files, err := filepath.Glob(filepath.Join(srcDir, filepath.FromSlash(pkg), "*.go"))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			bs, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			for _, line := range strings.Split(string(bs), "\n") {
+				if !strings.HasPrefix(line, "//go:generate ") {
+					continue
+				}
+				if fields := strings.Fields(line[len("//go:generate "):]); len(fields) > 0 {
+					names[fields[0]] = true
+				}
+			}
+		}
+	}
+	return names, nil
+}
+
+// commandDirs returns the import paths of the commands under dir, only the directories named
+// in names are checked if names is not nil
+func commandDirs(srcDir, dir string, names map[string]bool) ([]string, error) {
+	var cmds []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		if p != dir && (info.Name() == "testdata" || strings.HasPrefix(info.Name(), ".") || strings.HasPrefix(info.Name(), "_")) {
+			return filepath.SkipDir
+		}
+		if names != nil && !names[info.Name()] {
+			return nil
+		}
+		if pkg, err := build.ImportDir(p, 0); err == nil && pkg.IsCommand() {
+			rel, _ := filepath.Rel(srcDir, p)
+			cmds = append(cmds, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return cmds, err
+}
+
+// generateTools returns the import paths of the tools, they are configured in gop.yml or all the commands
+// under src/tools and the vendored commands run by the //go:generate directives of the packages.
+// A vendored command is found by its directory name, the first one wins if the name is not unique.
+func generateTools(projectRoot string, pkgs []string) ([]string, error) {
+	if len(config.Generate.Tools) > 0 {
+		return config.Generate.Tools, nil
+	}
+
+	srcDir := filepath.Join(projectRoot, "src")
+	var tools []string
+	if toolsDir := filepath.Join(srcDir, "tools"); IsDir(toolsDir) {
+		cmds, err := commandDirs(srcDir, toolsDir, nil)
+		if err != nil {
+			return nil, err
+		}
+		tools = append(tools, cmds...)
+	}
+
+	vendorDir := filepath.Join(srcDir, "vendor")
+	if !IsDir(vendorDir) {
+		return tools, nil
+	}
+	names, err := generateCommands(srcDir, pkgs)
+	if err != nil {
+		return nil, err
+	}
+	// the tools under src/tools are in front of the vendored ones with the same name
+	for _, tool := range tools {
+		delete(names, path.Base(tool))
+	}
+	if len(names) == 0 {
+		return tools, nil
+	}
+
+	cmds, err := commandDirs(srcDir, vendorDir, names)
+	if err != nil {
+		return nil, err
+	}
+	for _, cmd := range cmds {
+		if name := path.Base(cmd); names[name] {
+			delete(names, name)
+			tools = append(tools, strings.TrimPrefix(cmd, "vendor/"))
+		}
+	}
+	return tools, nil
+}
+
+// buildTools builds the tools into bin/tools
+func buildTools(projectRoot string, tools, envs []string) error {
+	toolsDir := filepath.Join(projectRoot, "bin", "tools")
+	var ext string
+	if runtime.GOOS == "windows" {
+		ext = ".exe"
+	}
+
+	for _, tool := range tools {
+		Println("Building tool", tool)
+		// go build doesn't look up vendor for the import paths of the command line
+		pkg := tool
+		if IsDir(filepath.Join(projectRoot, "src", "vendor", filepath.FromSlash(tool))) {
+			pkg = "./vendor/" + tool
+		}
+		cmd := NewCommand("build", "-o", filepath.Join(toolsDir, path.Base(tool)+ext), pkg)
+		cmd.Env = envs
+		if err := cmd.RunInDirPipeline(filepath.Join(projectRoot, "src"), os.Stdout, os.Stderr); err != nil {
+			return fmt.Errorf("build tool %s failed: %v", tool, err)
+		}
+	}
+	return nil
+}
+
+func runGenerate(ctx *cli.Context) error {
+	var args = make([]string, 0, len(ctx.Args()))
+	for _, arg := range ctx.Args() {
+		if arg == "-v" {
+			showLog = true
+		}
+		args = append(args, arg)
+	}
+
+	level, projectRoot, err := analysisDirLevel()
+	if err != nil {
+		return err
+	}
+
+	if err = loadConfig(filepath.Join(projectRoot, "gop.yml")); err != nil {
+		return err
+	}
+
+	// ./... means all the project packages
+	var targetName string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if args[0] != "./..." {
+			targetName = args[0]
+		}
+		args = args[1:]
+	}
+
+	var targetDir string
+	if targetName != "" {
+		target, err := analysisTarget(level, targetName, projectRoot)
+		if err != nil {
+			return err
+		}
+		targetDir = target.Dir
+	}
+
+	graph, err := loadPackageGraph(projectRoot, buildTags(args))
+	if err != nil {
+		return err
+	}
+	pkgs := graph.selectPackages(targetDir, nil)
+	if len(pkgs) == 0 {
+		return errors.New("no packages to generate")
+	}
+
+	envs := projectEnv(projectRoot)
+
+	tools, err := generateTools(projectRoot, pkgs)
+	if err != nil {
+		return err
+	}
+	if len(tools) > 0 {
+		if err := buildTools(projectRoot, tools, envs); err != nil {
+			return err
+		}
+		// the tools are put on PATH once they are built
+		envs = projectEnv(projectRoot)
+	}
+
+	before, err := snapshotFiles(projectRoot)
+	if err != nil {
+		return err
+	}
+
+	cmd := NewCommand("generate").AddArguments(args...).AddArguments(pkgs...)
+	cmd.Env = envs
+	genErr := cmd.RunInDirPipeline(filepath.Join(projectRoot, "src"), os.Stdout, os.Stderr)
+
+	// the files may be changed even if a generator failed
+	after, err := snapshotFiles(projectRoot)
+	if err != nil {
+		return err
+	}
+	changes := changedFiles(before, after)
+	if len(changes) == 0 {
+		fmt.Println("=== No files are changed")
+	} else {
+		fmt.Printf("=== %d files are changed\n", len(changes))
+		for _, change := range changes {
+			rel, _ := filepath.Rel(projectRoot, change[2:])
+			fmt.Printf("%s %s\n", change[:1], rel)
+		}
+	}
+	return genErr
+}
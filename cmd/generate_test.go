@@ -0,0 +1,52 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangedFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gop-generate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	writeFile(t, tmpDir, "src/main/main.go", "package main\n")
+	writeFile(t, tmpDir, "src/main/old.go", "package main\n")
+	writeFile(t, tmpDir, "src/main/same.go", "package main\n")
+	writeFile(t, tmpDir, "src/tools/gen/main.go", "package main\n\nfunc main() {}\n")
+	writeFile(t, tmpDir, "src/vendor/a/a.go", "package a\n")
+	writeFile(t, tmpDir, "src/vendor/github.com/a/stringer/main.go", "package main\n\nfunc main() {}\n")
+	writeFile(t, tmpDir, "src/vendor/github.com/a/unused/main.go", "package main\n\nfunc main() {}\n")
+
+	before, err := snapshotFiles(tmpDir)
+	assert.NoError(t, err)
+
+	writeFile(t, tmpDir, "src/main/main.go", "package main\n\nfunc main() {}\n")
+	writeFile(t, tmpDir, "src/main/same.go", "package main\n")
+	writeFile(t, tmpDir, "src/main/new_string.go", "package main\n")
+	writeFile(t, tmpDir, "src/vendor/a/a_string.go", "package a\n")
+	assert.NoError(t, os.Remove(filepath.Join(tmpDir, "src/main/old.go")))
+
+	after, err := snapshotFiles(tmpDir)
+	assert.NoError(t, err)
+	srcDir := filepath.Join(tmpDir, "src")
+	assert.EqualValues(t, []string{
+		"M " + filepath.Join(srcDir, "main", "main.go"),
+		"A " + filepath.Join(srcDir, "main", "new_string.go"),
+		"D " + filepath.Join(srcDir, "main", "old.go"),
+	}, changedFiles(before, after))
+
+	// the vendored commands are found from the directives, the ones of src/tools are preferred
+	writeFile(t, tmpDir, "src/main/gen.go", "package main\n\n//go:generate stringer -type=Mode\n//go:generate gen\n")
+	tools, err := generateTools(tmpDir, []string{"main"})
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"tools/gen", "github.com/a/stringer"}, tools)
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// globalGoPath returns the global GOPATH, the default one is used if it's not set
+func globalGoPath() string {
+	if gopath := os.Getenv("GOPATH"); gopath != "" {
+		return gopath
+	}
+	return build.Default.GOPATH
+}
+
+// projectInGoPath returns the import path of the project root if the project lives inside the global GOPATH,
+// i.e. github.com/me/myproject for $GOPATH/src/github.com/me/myproject
+func projectInGoPath(projectRoot string) (string, bool) {
+	for _, gopath := range filepath.SplitList(globalGoPath()) {
+		if gopath == "" {
+			continue
+		}
+		srcDir := filepath.Join(gopath, "src")
+		if resolved, err := filepath.EvalSymlinks(srcDir); err == nil {
+			srcDir = resolved
+		}
+		rel, err := filepath.Rel(srcDir, projectRoot)
+		if err == nil && rel != "." && !isOutsideDir(rel) {
+			return filepath.ToSlash(rel), true
+		}
+	}
+	return "", false
+}
+
+// globalProjectImport returns the project import path of a package imported through the global GOPATH,
+// i.e. github.com/me/myproject/src/models is models and github.com/me/myproject/src/vendor/github.com/lunny/log
+// is github.com/lunny/log. The false is returned if the package is not in the project.
+func globalProjectImport(projectRoot, imp string) (string, bool) {
+	prefix, ok := projectInGoPath(projectRoot)
+	if !ok || !strings.HasPrefix(imp, prefix+"/src/") {
+		return "", false
+	}
+	imp = strings.TrimPrefix(imp, prefix+"/src/")
+	return strings.TrimPrefix(imp, "vendor/"), true
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectInGoPath(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gop-gopath")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	tmpDir, err = filepath.EvalSymlinks(tmpDir)
+	assert.NoError(t, err)
+
+	oldGoPath := os.Getenv("GOPATH")
+	defer os.Setenv("GOPATH", oldGoPath)
+	gopath := filepath.Join(tmpDir, "go")
+	os.Setenv("GOPATH", gopath)
+
+	projectRoot := filepath.Join(gopath, "src", "github.com", "me", "myproject")
+	assert.NoError(t, os.MkdirAll(projectRoot, os.ModePerm))
+	prefix, ok := projectInGoPath(projectRoot)
+	assert.True(t, ok)
+	assert.EqualValues(t, "github.com/me/myproject", prefix)
+
+	pkg, ok := globalProjectImport(projectRoot, "github.com/me/myproject/src/models")
+	assert.True(t, ok)
+	assert.EqualValues(t, "models", pkg)
+	pkg, ok = globalProjectImport(projectRoot, "github.com/me/myproject/src/vendor/github.com/lunny/log")
+	assert.True(t, ok)
+	assert.EqualValues(t, "github.com/lunny/log", pkg)
+	_, ok = globalProjectImport(projectRoot, "github.com/me/other")
+	assert.False(t, ok)
+
+	// the project is in front of the global GOPATH
+	assert.EqualValues(t, []envVar{{"GOPATH", projectRoot + string(os.PathListSeparator) + gopath}}, projectVars(projectRoot))
+
+	outside := filepath.Join(tmpDir, "myproject")
+	_, ok = projectInGoPath(outside)
+	assert.False(t, ok)
+	assert.EqualValues(t, []envVar{{"GOPATH", outside}}, projectVars(outside))
+}
+
+func TestListImportsConcurrently(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gop-gopath")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	tmpDir, err = filepath.EvalSymlinks(tmpDir)
+	assert.NoError(t, err)
+
+	oldGoPath := os.Getenv("GOPATH")
+	defer os.Setenv("GOPATH", oldGoPath)
+	gopath := filepath.Join(tmpDir, "go")
+	os.Setenv("GOPATH", gopath)
+
+	projectRoot := filepath.Join(gopath, "src", "github.com", "me", "myproject")
+	writeFile(t, projectRoot, "src/models/models.go", "package models\n")
+	for _, name := range []string{"api", "worker", "admin", "cron"} {
+		writeFile(t, projectRoot, "src/"+name+"/main.go",
+			"package main\n\nimport _ \"github.com/me/myproject/src/models\"\n\nfunc main() {}\n")
+	}
+
+	// the warnings of the imports through the global GOPATH are shared by the targets built in parallel
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		for _, name := range []string{"api", "worker", "admin", "cron"} {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				imports, err := ListImports(projectRoot, name, projectRoot, filepath.Join(projectRoot, "src"), "", false)
+				assert.NoError(t, err)
+				if assert.Len(t, imports, 1) {
+					assert.EqualValues(t, "models", imports[0].Name)
+				}
+			}(name)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,581 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIConfig   = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayer    = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeOCILayerGz  = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	defaultImageWorkDir = "/app"
+)
+
+// ImageConfig the container image configuration of a target in gop.yml
+type ImageConfig struct {
+	Name       string            `yaml:"name"`
+	Base       string            `yaml:"base"`
+	Output     string            `yaml:"output"`
+	WorkDir    string            `yaml:"workdir"`
+	Entrypoint []string          `yaml:"entrypoint"`
+	Cmd        []string          `yaml:"cmd"`
+	Env        []string          `yaml:"env"`
+	Ports      []string          `yaml:"ports"`
+	Labels     map[string]string `yaml:"labels"`
+}
+
+type (
+	ociDescriptor struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Size        int64             `json:"size"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+
+	ociManifest struct {
+		SchemaVersion int             `json:"schemaVersion"`
+		MediaType     string          `json:"mediaType,omitempty"`
+		Config        ociDescriptor   `json:"config"`
+		Layers        []ociDescriptor `json:"layers"`
+	}
+
+	ociIndex struct {
+		SchemaVersion int             `json:"schemaVersion"`
+		Manifests     []ociDescriptor `json:"manifests"`
+	}
+
+	dockerManifest struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+
+	imageContainerConfig struct {
+		User         string              `json:"User,omitempty"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+		Env          []string            `json:"Env,omitempty"`
+		Entrypoint   []string            `json:"Entrypoint,omitempty"`
+		Cmd          []string            `json:"Cmd,omitempty"`
+		WorkingDir   string              `json:"WorkingDir,omitempty"`
+		Labels       map[string]string   `json:"Labels,omitempty"`
+	}
+
+	imageHistory struct {
+		Created    string `json:"created,omitempty"`
+		CreatedBy  string `json:"created_by,omitempty"`
+		EmptyLayer bool   `json:"empty_layer,omitempty"`
+	}
+
+	imageRootFS struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	}
+
+	imageConfigFile struct {
+		Architecture string               `json:"architecture"`
+		OS           string               `json:"os"`
+		Created      string               `json:"created,omitempty"`
+		Config       imageContainerConfig `json:"config"`
+		RootFS       imageRootFS          `json:"rootfs"`
+		History      []imageHistory       `json:"history,omitempty"`
+	}
+
+	// imageLayer is a layer blob with its descriptor and uncompressed digest
+	imageLayer struct {
+		Descriptor ociDescriptor
+		DiffID     string
+		Content    []byte
+	}
+)
+
+func sha256Digest(content []byte) string {
+	h := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+func isGzip(content []byte) bool {
+	return len(content) > 2 && content[0] == 0x1f && content[1] == 0x8b
+}
+
+func newImageLayer(content []byte, diffID string) imageLayer {
+	mediaType := mediaTypeOCILayer
+	if isGzip(content) {
+		mediaType = mediaTypeOCILayerGz
+	}
+	digest := sha256Digest(content)
+	if diffID == "" {
+		diffID = digest
+	}
+	return imageLayer{
+		Descriptor: ociDescriptor{
+			MediaType: mediaType,
+			Digest:    digest,
+			Size:      int64(len(content)),
+		},
+		DiffID:  diffID,
+		Content: content,
+	}
+}
+
+// readTarFiles reads all the regular files of a tar archive into memory
+func readTarFiles(r io.Reader) (map[string][]byte, error) {
+	var files = make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Clean(hdr.Name)] = content
+	}
+	return files, nil
+}
+
+// loadBaseImage loads the config and layers of a docker save or OCI layout tarball
+func loadBaseImage(tarPath string) (*imageConfigFile, []imageLayer, error) {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	files, err := readTarFiles(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read base image %s failed: %v", tarPath, err)
+	}
+
+	var configPath string
+	var layerPaths []string
+	if bs, ok := files["manifest.json"]; ok {
+		var manifests []dockerManifest
+		if err = json.Unmarshal(bs, &manifests); err != nil {
+			return nil, nil, err
+		}
+		if len(manifests) == 0 {
+			return nil, nil, fmt.Errorf("base image %s has no manifest", tarPath)
+		}
+		configPath = manifests[0].Config
+		layerPaths = manifests[0].Layers
+	} else if bs, ok := files["index.json"]; ok {
+		var index ociIndex
+		if err = json.Unmarshal(bs, &index); err != nil {
+			return nil, nil, err
+		}
+		if len(index.Manifests) == 0 {
+			return nil, nil, fmt.Errorf("base image %s has no manifest", tarPath)
+		}
+		var manifest ociManifest
+		if err = json.Unmarshal(files[blobPath(index.Manifests[0].Digest)], &manifest); err != nil {
+			return nil, nil, err
+		}
+		configPath = blobPath(manifest.Config.Digest)
+		for _, layer := range manifest.Layers {
+			layerPaths = append(layerPaths, blobPath(layer.Digest))
+		}
+	} else {
+		return nil, nil, fmt.Errorf("%s is neither a docker save tarball nor an OCI image layout", tarPath)
+	}
+
+	var cfg imageConfigFile
+	if err = json.Unmarshal(files[path.Clean(configPath)], &cfg); err != nil {
+		return nil, nil, fmt.Errorf("parse config of base image %s failed: %v", tarPath, err)
+	}
+	if len(cfg.RootFS.DiffIDs) != len(layerPaths) {
+		return nil, nil, fmt.Errorf("base image %s has %d layers but %d diff ids", tarPath, len(layerPaths), len(cfg.RootFS.DiffIDs))
+	}
+
+	var layers = make([]imageLayer, 0, len(layerPaths))
+	for i, p := range layerPaths {
+		content, ok := files[path.Clean(p)]
+		if !ok {
+			return nil, nil, fmt.Errorf("layer %s not found in base image %s", p, tarPath)
+		}
+		layers = append(layers, newImageLayer(content, cfg.RootFS.DiffIDs[i]))
+	}
+	return &cfg, layers, nil
+}
+
+func blobPath(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+// buildAppLayer creates an uncompressed layer which contains all the files of releaseDir under workDir.
+// The entries are sorted and their owners, permissions and modification times are normalized.
+func buildAppLayer(releaseDir, workDir string, mtime time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	// the parent directories of workDir
+	workDir = strings.Trim(path.Clean("/"+workDir), "/")
+	var parent string
+	for _, p := range strings.Split(workDir, "/") {
+		if p == "" {
+			continue
+		}
+		parent = path.Join(parent, p)
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     parent + "/",
+			Mode:     0755,
+			ModTime:  mtime,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	err := filepath.Walk(releaseDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(releaseDir, p)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		// skip the hidden files such as the fingerprint
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		name := path.Join(workDir, filepath.ToSlash(relPath))
+		switch {
+		case info.IsDir():
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     name + "/",
+				Mode:     0755,
+				ModTime:  mtime,
+			})
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeSymlink,
+				Name:     name,
+				Linkname: link,
+				Mode:     0777,
+				ModTime:  mtime,
+			})
+		case info.Mode().IsRegular():
+			var mode int64 = 0644
+			if info.Mode()&0111 != 0 {
+				mode = 0755
+			}
+			if err = tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     name,
+				Mode:     mode,
+				Size:     info.Size(),
+				ModTime:  mtime,
+			}); err != nil {
+				return err
+			}
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// mergeEnv overrides the environment variables of base with envs which have the same keys
+func mergeEnv(base, envs []string) []string {
+	var res = append([]string{}, base...)
+	for _, env := range envs {
+		key := strings.SplitN(env, "=", 2)[0]
+		var replaced bool
+		for i, e := range res {
+			if strings.SplitN(e, "=", 2)[0] == key {
+				res[i] = env
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			res = append(res, env)
+		}
+	}
+	return res
+}
+
+// imageRef returns the repository and tag of the image name
+func imageRef(name string) (string, string) {
+	// the colon of a registry port is before the last slash
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[:i], name[i+1:]
+	}
+	return name, "latest"
+}
+
+// writeImage writes a tarball which is both an OCI image layout and docker save compatible,
+// the image contains the base layers and a layer of releaseDir.
+func writeImage(w io.Writer, target *Target, releaseDir, goos, goarch string, mtime time.Time) error {
+	var image = target.Image
+	var (
+		cfg    *imageConfigFile
+		layers []imageLayer
+		err    error
+	)
+	if image.Base == "" || image.Base == "scratch" {
+		cfg = &imageConfigFile{
+			RootFS: imageRootFS{Type: "layers"},
+		}
+	} else {
+		if cfg, layers, err = loadBaseImage(image.Base); err != nil {
+			return err
+		}
+	}
+
+	var workDir = image.WorkDir
+	if workDir == "" {
+		workDir = defaultImageWorkDir
+	}
+	workDir = path.Clean("/" + workDir)
+
+	appLayer, err := buildAppLayer(releaseDir, workDir, mtime)
+	if err != nil {
+		return err
+	}
+	layers = append(layers, newImageLayer(appLayer, ""))
+
+	created := mtime.UTC().Format(time.RFC3339)
+	cfg.Architecture = goarch
+	cfg.OS = goos
+	cfg.Created = created
+	cfg.RootFS.Type = "layers"
+	cfg.RootFS.DiffIDs = append(cfg.RootFS.DiffIDs, layers[len(layers)-1].DiffID)
+	cfg.History = append(cfg.History, imageHistory{
+		Created:   created,
+		CreatedBy: "gop release --image",
+	})
+
+	cfg.Config.WorkingDir = workDir
+	cfg.Config.Env = mergeEnv(cfg.Config.Env, image.Env)
+	if len(image.Entrypoint) > 0 {
+		cfg.Config.Entrypoint = image.Entrypoint
+	} else {
+		cfg.Config.Entrypoint = []string{path.Join(workDir, target.Name)}
+	}
+	if len(image.Cmd) > 0 || len(image.Entrypoint) == 0 {
+		cfg.Config.Cmd = image.Cmd
+	}
+	for _, port := range image.Ports {
+		if !strings.Contains(port, "/") {
+			port = port + "/tcp"
+		}
+		if cfg.Config.ExposedPorts == nil {
+			cfg.Config.ExposedPorts = make(map[string]struct{})
+		}
+		cfg.Config.ExposedPorts[port] = struct{}{}
+	}
+	for k, v := range image.Labels {
+		if cfg.Config.Labels == nil {
+			cfg.Config.Labels = make(map[string]string)
+		}
+		cfg.Config.Labels[k] = v
+	}
+
+	cfgContent, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config: ociDescriptor{
+			MediaType: mediaTypeOCIConfig,
+			Digest:    sha256Digest(cfgContent),
+			Size:      int64(len(cfgContent)),
+		},
+	}
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, layer.Descriptor)
+	}
+	manifestContent, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	var name = image.Name
+	if name == "" {
+		name = target.Name
+	}
+	repo, tag := imageRef(name)
+	repoTag := repo + ":" + tag
+
+	indexContent, err := json.Marshal(ociIndex{
+		SchemaVersion: 2,
+		Manifests: []ociDescriptor{
+			{
+				MediaType: mediaTypeOCIManifest,
+				Digest:    sha256Digest(manifestContent),
+				Size:      int64(len(manifestContent)),
+				Annotations: map[string]string{
+					"io.containerd.image.name":          repoTag,
+					"org.opencontainers.image.ref.name": tag,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var dockerManifestLayers = make([]string, 0, len(layers))
+	for _, layer := range layers {
+		dockerManifestLayers = append(dockerManifestLayers, blobPath(layer.Descriptor.Digest))
+	}
+	dockerManifestContent, err := json.Marshal([]dockerManifest{
+		{
+			Config:   blobPath(manifest.Config.Digest),
+			RepoTags: []string{repoTag},
+			Layers:   dockerManifestLayers,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var files = map[string][]byte{
+		"oci-layout":                            []byte(`{"imageLayoutVersion":"1.0.0"}`),
+		"index.json":                            indexContent,
+		"manifest.json":                         dockerManifestContent,
+		blobPath(manifest.Config.Digest):        cfgContent,
+		blobPath(sha256Digest(manifestContent)): manifestContent,
+	}
+	for _, layer := range layers {
+		files[blobPath(layer.Descriptor.Digest)] = layer.Content
+	}
+
+	var names = make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tar.NewWriter(w)
+	for _, dir := range []string{"blobs/", "blobs/sha256/"} {
+		if err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     dir,
+			Mode:     0755,
+			ModTime:  mtime,
+		}); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		if err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(files[name])),
+			ModTime:  mtime,
+		}); err != nil {
+			return err
+		}
+		if _, err = tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// imageGoEnv returns the GOOS and GOARCH the image will be built for, images are always linux
+func imageGoEnv() (string, string, error) {
+	goos := os.Getenv("GOOS")
+	if goos == "" {
+		goos = "linux"
+	}
+	if goos != "linux" {
+		return "", "", errors.New("container images only support GOOS=linux")
+	}
+	goarch := os.Getenv("GOARCH")
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+	return goos, goarch, nil
+}
+
+// releaseImage writes the image of the released target to the image output path
+func releaseImage(projectRoot string, target *Target, releaseDir, goos, goarch string, mtime time.Time) error {
+	output := target.Image.Output
+	if output == "" {
+		output = filepath.Join("bin", target.Name+".tar")
+	}
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(projectRoot, output)
+	}
+	if target.Image.Base != "" && target.Image.Base != "scratch" && !filepath.IsAbs(target.Image.Base) {
+		target.Image.Base = filepath.Join(projectRoot, target.Image.Base)
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+
+	err = writeImage(f, target, releaseDir, goos, goarch, mtime)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// a partial image can't be loaded
+		os.Remove(output)
+		return err
+	}
+
+	fmt.Println("Image written to", output)
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteImage(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), fmt.Sprintf("%d", time.Now().UnixNano()))
+	defer os.RemoveAll(tmpDir)
+
+	writeFile(t, tmpDir, "app", "binary")
+	assert.NoError(t, os.Chmod(filepath.Join(tmpDir, "app"), 0755))
+	writeFile(t, tmpDir, "public/index.html", "<html></html>")
+	writeFile(t, tmpDir, ".app.fingerprint", "xxx")
+
+	target := &Target{
+		Name: "app",
+		Image: ImageConfig{
+			Name:   "example.com:5000/app",
+			Env:    []string{"MODE=prod"},
+			Ports:  []string{"8000"},
+			Labels: map[string]string{"maintainer": "gop"},
+		},
+	}
+
+	mtime := time.Unix(1500000000, 0)
+	var buf1, buf2 bytes.Buffer
+	assert.NoError(t, writeImage(&buf1, target, tmpDir, "linux", "amd64", mtime))
+	assert.NoError(t, writeImage(&buf2, target, tmpDir, "linux", "amd64", mtime))
+	assert.Equal(t, buf1.Bytes(), buf2.Bytes())
+
+	files, err := readTarFiles(&buf1)
+	assert.NoError(t, err)
+	assert.Contains(t, files, "oci-layout")
+	assert.Contains(t, files, "index.json")
+
+	var manifests []dockerManifest
+	assert.NoError(t, json.Unmarshal(files["manifest.json"], &manifests))
+	assert.Len(t, manifests, 1)
+	assert.EqualValues(t, []string{"example.com:5000/app:latest"}, manifests[0].RepoTags)
+	assert.Len(t, manifests[0].Layers, 1)
+
+	layer := files[manifests[0].Layers[0]]
+	assert.Equal(t, manifests[0].Layers[0], blobPath(sha256Digest(layer)))
+
+	var cfg imageConfigFile
+	assert.NoError(t, json.Unmarshal(files[manifests[0].Config], &cfg))
+	assert.Equal(t, "linux", cfg.OS)
+	assert.EqualValues(t, []string{"/app/app"}, cfg.Config.Entrypoint)
+	assert.EqualValues(t, []string{"MODE=prod"}, cfg.Config.Env)
+	assert.Contains(t, cfg.Config.ExposedPorts, "8000/tcp")
+	assert.Equal(t, "gop", cfg.Config.Labels["maintainer"])
+	assert.EqualValues(t, []string{sha256Digest(layer)}, cfg.RootFS.DiffIDs)
+
+	layerFiles, err := readTarFiles(bytes.NewReader(layer))
+	assert.NoError(t, err)
+	assert.Equal(t, "binary", string(layerFiles["app/app"]))
+	assert.Equal(t, "<html></html>", string(layerFiles["app/public/index.html"]))
+	assert.NotContains(t, layerFiles, "app/.app.fingerprint")
+
+	// use the image as a base image
+	basePath := filepath.Join(tmpDir, "base.tar")
+	f, err := os.Create(basePath)
+	assert.NoError(t, err)
+	_, err = f.Write(buf2.Bytes())
+	assert.NoError(t, err)
+	f.Close()
+
+	baseCfg, baseLayers, err := loadBaseImage(basePath)
+	assert.NoError(t, err)
+	assert.Len(t, baseLayers, 1)
+	assert.EqualValues(t, []string{"MODE=prod"}, baseCfg.Config.Env)
+}
+
+func TestImageRef(t *testing.T) {
+	repo, tag := imageRef("app")
+	assert.Equal(t, "app", repo)
+	assert.Equal(t, "latest", tag)
+
+	repo, tag = imageRef("example.com:5000/app:1.0")
+	assert.Equal(t, "example.com:5000/app", repo)
+	assert.Equal(t, "1.0", tag)
+}
@@ -0,0 +1,343 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/build"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the built-in checkers of gop vet
+const (
+	checkerVet           = "vet"
+	checkerMissingVendor = "missing-vendor"
+	checkerMainImport    = "main-import"
+	checkerGopath        = "gopath"
+)
+
+var builtinCheckers = []string{checkerVet, checkerMissingVendor, checkerMainImport, checkerGopath}
+
+const defaultVetBaseline = "vet-baseline.txt"
+
+var (
+	// vetIssueRegexp matches the issues written by vetIssue.String
+	vetIssueRegexp = regexp.MustCompile(`^(.+?):(\d+): \[([^\]]+)\] (.*)$`)
+	// vetPrefixRegexp matches the prefix of the issues reported as vet: file:line: message
+	vetPrefixRegexp = regexp.MustCompile(`(?m)^vet: `)
+)
+
+// vetIssue is an issue found by a checker, the file is relative to the project root
+type vetIssue struct {
+	File    string
+	Line    int
+	Checker string
+	Message string
+}
+
+// String returns file:line: [checker] message
+func (i vetIssue) String() string {
+	return fmt.Sprintf("%s:%d: [%s] %s", i.File, i.Line, i.Checker, i.Message)
+}
+
+// key identifies the issue in the baseline, the line is left out so the known issues
+// are still suppressed when the code around them changes
+func (i vetIssue) key() string {
+	return fmt.Sprintf("%s: [%s] %s", i.File, i.Checker, i.Message)
+}
+
+func sortIssues(issues []vetIssue) {
+	sort.Slice(issues, func(i, j int) bool {
+		a, b := issues[i], issues[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Checker != b.Checker {
+			return a.Checker < b.Checker
+		}
+		return a.Message < b.Message
+	})
+}
+
+// enabledCheckers returns the enabled built-in checkers, all of them are enabled by default
+func enabledCheckers(checks []string) (map[string]bool, error) {
+	if len(checks) == 0 {
+		checks = builtinCheckers
+	}
+
+	var enabled = make(map[string]bool, len(checks))
+	for _, check := range checks {
+		var found bool
+		for _, c := range builtinCheckers {
+			if c == check {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown check %s, it should be one of %s", check, strings.Join(builtinCheckers, ", "))
+		}
+		enabled[check] = true
+	}
+	return enabled, nil
+}
+
+// vetBaselinePath returns the baseline file of the project
+func vetBaselinePath(projectRoot string) string {
+	p := config.Vet.Baseline
+	if p == "" {
+		p = defaultVetBaseline
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(projectRoot, p)
+	}
+	return p
+}
+
+// loadBaseline returns the number of every known issue in the baseline, it's empty if there is no baseline
+func loadBaseline(p string) (map[string]int, error) {
+	var baseline = make(map[string]int)
+	bs, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return baseline, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(bs))
+	for scanner.Scan() {
+		matches := vetIssueRegexp.FindStringSubmatch(scanner.Text())
+		if matches == nil {
+			continue
+		}
+		issue := vetIssue{File: matches[1], Checker: matches[3], Message: matches[4]}
+		baseline[issue.key()]++
+	}
+	return baseline, scanner.Err()
+}
+
+// writeBaseline writes the issues as the known issues
+func writeBaseline(p string, issues []vetIssue) error {
+	var buf bytes.Buffer
+	for _, issue := range issues {
+		buf.WriteString(issue.String())
+		buf.WriteString("\n")
+	}
+	return ioutil.WriteFile(p, buf.Bytes(), 0644)
+}
+
+// filterBaseline returns the issues which are not known in the baseline and the number of the known ones
+func filterBaseline(issues []vetIssue, baseline map[string]int) ([]vetIssue, int) {
+	var known = make(map[string]int, len(baseline))
+	for key, n := range baseline {
+		known[key] = n
+	}
+
+	var reported []vetIssue
+	var suppressed int
+	for _, issue := range issues {
+		key := issue.key()
+		if known[key] > 0 {
+			known[key]--
+			suppressed++
+			continue
+		}
+		reported = append(reported, issue)
+	}
+	return reported, suppressed
+}
+
+// issuesFromOutput parses the file:line: message lines in the output of a checker which runs in src
+func issuesFromOutput(projectRoot, checker string, output []byte) []vetIssue {
+	output = vetPrefixRegexp.ReplaceAll(output, nil)
+
+	var issues []vetIssue
+	for _, e := range parseBuildErrors(output, filepath.Join(projectRoot, "src")) {
+		// the issues are kept in one line
+		lines := strings.Split(e.Message, "\n")
+		for i := range lines {
+			lines[i] = strings.TrimSpace(lines[i])
+		}
+		issues = append(issues, vetIssue{
+			File:    projectRelPath(projectRoot, e.File),
+			Line:    e.Line,
+			Checker: checker,
+			Message: strings.Join(lines, " "),
+		})
+	}
+	return issues
+}
+
+// projectRelPath returns the slash separated path relative to the project root if possible
+func projectRelPath(projectRoot, p string) string {
+	if rel, err := filepath.Rel(projectRoot, p); err == nil && !strings.HasPrefix(rel, "..") {
+		p = rel
+	}
+	return filepath.ToSlash(p)
+}
+
+// runGoVet returns the issues reported by go vet
+func runGoVet(projectRoot string, args, pkgs, envs []string) ([]vetIssue, error) {
+	var output bytes.Buffer
+	cmd := NewCommand("vet").AddArguments(args...).AddArguments(pkgs...)
+	cmd.Env = envs
+	err := cmd.RunInDirPipeline(filepath.Join(projectRoot, "src"), &output, &output)
+
+	issues := issuesFromOutput(projectRoot, checkerVet, output.Bytes())
+	if err != nil && len(issues) == 0 {
+		return nil, fmt.Errorf("go vet failed: %v\n%s", err, output.String())
+	}
+	// the failures without positions like import cycles must not be hidden by the other issues
+	if unparsed := unparsedOutput(vetPrefixRegexp.ReplaceAll(output.Bytes(), nil)); err != nil && len(unparsed) > 0 {
+		return nil, fmt.Errorf("go vet failed: %v\n%s", err, strings.Join(unparsed, "\n"))
+	}
+	return issues, nil
+}
+
+// unparsedOutput returns the lines of the output which are not the file:line: issues or their indented
+// lines, the package headers like # models are left out
+func unparsedOutput(output []byte) []string {
+	var lines []string
+	var inIssue bool
+	for _, line := range strings.Split(string(output), "\n") {
+		if inIssue && strings.HasPrefix(line, "\t") {
+			continue
+		}
+		inIssue = buildErrorRegexp.MatchString(line)
+		if !inIssue && strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "# ") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// runLinter returns the issues reported by the external linter, the exit code of the linter is ignored
+// since most linters fail when they report issues
+func runLinter(projectRoot string, linter LinterConfig, pkgs, envs []string) ([]vetIssue, error) {
+	if len(linter.Command) == 0 {
+		return nil, fmt.Errorf("no command of the linter %s", linter.Name)
+	}
+	name := linter.Name
+	if name == "" {
+		name = filepath.Base(linter.Command[0])
+	}
+
+	var output bytes.Buffer
+	cmd := &Command{
+		name: linter.Command[0],
+		args: append(append([]string{}, linter.Command[1:]...), pkgs...),
+		Env:  envs,
+	}
+	err := cmd.RunInDirPipeline(filepath.Join(projectRoot, "src"), &output, &output)
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return nil, fmt.Errorf("run linter %s failed: %v", name, err)
+	}
+	return issuesFromOutput(projectRoot, name, output.Bytes()), nil
+}
+
+// checkImports checks the imports of the packages. The imports missing from vendor and the imports
+// found only in the global GOPATH cannot be built by gop, and the main directories of the other
+// targets should not be imported.
+func checkImports(projectRoot string, pkgs []string, enabled map[string]bool) []vetIssue {
+	ctxt := build.Default
+	ctxt.GOPATH = projectRoot
+	srcDir := filepath.Join(projectRoot, "src")
+
+	var targetDirs = make(map[string]string)
+	for _, t := range config.Targets {
+		targetDirs[filepath.Join(srcDir, t.Dir)] = t.Name
+	}
+
+	var issues []vetIssue
+	for _, pkg := range pkgs {
+		dir := filepath.Join(srcDir, filepath.FromSlash(pkg))
+		p, err := ctxt.ImportDir(dir, 0)
+		if err != nil {
+			continue
+		}
+
+		var positions = make(map[string][]token.Position)
+		for _, m := range []map[string][]token.Position{p.ImportPos, p.TestImportPos, p.XTestImportPos} {
+			for imp, pos := range m {
+				positions[imp] = append(positions[imp], pos...)
+			}
+		}
+
+		for imp, pos := range positions {
+			if imp == "C" {
+				continue
+			}
+
+			var checker, message string
+			found, err := ctxt.Import(imp, dir, build.FindOnly)
+			switch {
+			case err == nil && found.Goroot:
+				continue
+			case err == nil:
+				name, ok := targetDirs[found.Dir]
+				if !ok || found.Dir == dir || strings.HasPrefix(dir, found.Dir+string(filepath.Separator)) {
+					continue
+				}
+				checker, message = checkerMainImport, fmt.Sprintf("imports %s which is the main directory of the target %s", imp, name)
+			default:
+				// the global GOPATH, which may contain the project itself
+				if projectPkg, ok := globalProjectImport(projectRoot, imp); ok {
+					checker, message = checkerGopath, fmt.Sprintf("imports %s through the global GOPATH which is ambiguous, import %s instead", imp, projectPkg)
+				} else if _, err := build.Default.Import(imp, "", build.FindOnly); err == nil {
+					checker, message = checkerGopath, fmt.Sprintf("imports %s from GOPATH which is not vendored, run gop ensure", imp)
+				} else {
+					checker, message = checkerMissingVendor, fmt.Sprintf("imports %s which is missing from vendor, run gop ensure -g", imp)
+				}
+			}
+			if !enabled[checker] {
+				continue
+			}
+
+			for _, p := range pos {
+				issues = append(issues, vetIssue{
+					File:    projectRelPath(projectRoot, p.Filename),
+					Line:    p.Line,
+					Checker: checker,
+					Message: message,
+				})
+			}
+		}
+	}
+	return issues
+}
+
+// vetResults returns the result of every package, the packages with issues failed
+func vetResults(issues []vetIssue, pkgs []string) []testResult {
+	var counts = make(map[string]int)
+	for _, issue := range issues {
+		if strings.HasPrefix(issue.File, "src/") {
+			counts[path.Dir(strings.TrimPrefix(issue.File, "src/"))]++
+		}
+	}
+
+	var results = make([]testResult, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		if n := counts[pkg]; n > 0 {
+			results = append(results, testResult{Package: pkg, Status: "FAIL", Detail: "issues: " + strconv.Itoa(n)})
+		} else {
+			results = append(results, testResult{Package: pkg, Status: "ok"})
+		}
+	}
+	return results
+}
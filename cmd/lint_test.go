@@ -0,0 +1,84 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVetIssues(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gop-vet")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	issues := issuesFromOutput(tmpDir, checkerVet, []byte(`# models
+vet: models/user.go:12:2: unreachable code
+models/user.go:20: Printf format %d has arg name of wrong type string
+# routes
+routes/api.go:8:5: self-assignment of a to a
+	second line
+`))
+	sortIssues(issues)
+	assert.EqualValues(t, []vetIssue{
+		{File: "src/models/user.go", Line: 12, Checker: "vet", Message: "unreachable code"},
+		{File: "src/models/user.go", Line: 20, Checker: "vet", Message: "Printf format %d has arg name of wrong type string"},
+		{File: "src/routes/api.go", Line: 8, Checker: "vet", Message: "self-assignment of a to a second line"},
+	}, issues)
+
+	// the import cycle has no position
+	assert.EqualValues(t, []string{"package other", "\timports models: import cycle not allowed"}, unparsedOutput([]byte(`# models
+models/user.go:12:2: unreachable code
+	second line
+package other
+	imports models: import cycle not allowed
+`)))
+
+	assert.EqualValues(t, []testResult{
+		{Package: "main", Status: "ok"},
+		{Package: "models", Status: "FAIL", Detail: "issues: 2"},
+		{Package: "routes", Status: "FAIL", Detail: "issues: 1"},
+	}, vetResults(issues, []string{"main", "models", "routes"}))
+
+	// the known issues are still suppressed after the lines moved
+	baselinePath := filepath.Join(tmpDir, "vet-baseline.txt")
+	assert.NoError(t, writeBaseline(baselinePath, issues[:2]))
+	baseline, err := loadBaseline(baselinePath)
+	assert.NoError(t, err)
+	issues[0].Line = 15
+	reported, known := filterBaseline(issues, baseline)
+	assert.EqualValues(t, issues[2:], reported)
+	assert.EqualValues(t, 2, known)
+}
+
+func TestCheckImports(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gop-vet")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	writeFile(t, tmpDir, "src/main/main.go", "package main\n\nimport (\n\t\"fmt\"\n\n\t\"models\"\n)\n\nfunc main() { fmt.Println(models.Name) }\n")
+	writeFile(t, tmpDir, "src/models/models.go", "package models\n\nimport (\n\t\"github.com/a/b\"\n\t\"github.com/gop-missing/c\"\n\t\"main\"\n)\n\nvar Name = b.B + c.C + main.M\n")
+	writeFile(t, tmpDir, "src/vendor/github.com/a/b/b.go", "package b\n\nvar B = \"b\"\n")
+
+	config = Config{Targets: []Target{{Name: "app", Dir: "main"}}}
+	defer func() {
+		config = Config{}
+	}()
+	enabled, err := enabledCheckers(nil)
+	assert.NoError(t, err)
+	issues := checkImports(tmpDir, []string{"main", "models"}, enabled)
+	sortIssues(issues)
+	assert.EqualValues(t, []vetIssue{
+		{File: "src/models/models.go", Line: 5, Checker: checkerMissingVendor, Message: "imports github.com/gop-missing/c which is missing from vendor, run gop ensure -g"},
+		{File: "src/models/models.go", Line: 6, Checker: checkerMainImport, Message: "imports main which is the main directory of the target app"},
+	}, issues)
+
+	_, err = enabledCheckers([]string{"golint"})
+	assert.Error(t, err)
+}
@@ -4,7 +4,10 @@
 
 package cmd
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+)
 
 var (
 	showLog bool
@@ -33,3 +36,10 @@ func Error(a ...interface{}) {
 func Errorf(format string, a ...interface{}) {
 	fmt.Printf(format, a...)
 }
+
+// Fprintln println content to w according the flag
+func Fprintln(w io.Writer, a ...interface{}) {
+	if showLog {
+		fmt.Fprintln(w, a...)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// prefixColors are the ANSI colors of the target prefixes
+var prefixColors = []int{36, 33, 32, 35, 34, 31}
+
+// isTerminal returns true if the file is a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize colors s with the i-th color if the output is a terminal and NO_COLOR is not set
+func colorize(s string, i int) string {
+	if os.Getenv("NO_COLOR") != "" || runtime.GOOS == "windows" || !isTerminal(os.Stdout) {
+		return s
+	}
+	return fmt.Sprintf("\x1b[%dm%s\x1b[0m", prefixColors[i%len(prefixColors)], s)
+}
+
+// prefixWriter writes every line with the prefix, the writers sharing the same lock will not
+// interleave their lines. The last line without a newline is kept until the newline is written.
+type prefixWriter struct {
+	w      io.Writer
+	lock   *sync.Mutex
+	prefix string
+	buf    []byte
+}
+
+func newPrefixWriter(w io.Writer, lock *sync.Mutex, prefix string) *prefixWriter {
+	return &prefixWriter{
+		w:      w,
+		lock:   lock,
+		prefix: prefix,
+	}
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := fmt.Fprintf(pw.w, "%s %s\n", pw.prefix, pw.buf[:i]); err != nil {
+			return 0, err
+		}
+		pw.buf = pw.buf[i+1:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,26 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	var lock sync.Mutex
+	api := newPrefixWriter(&buf, &lock, "[api]")
+	worker := newPrefixWriter(&buf, &lock, "[worker]")
+
+	api.Write([]byte("listening"))
+	worker.Write([]byte("started\nconnected\n"))
+	api.Write([]byte(" on :8000\n"))
+
+	assert.Equal(t, "[worker] started\n[worker] connected\n[api] listening on :8000\n", buf.String())
+}
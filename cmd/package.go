@@ -14,6 +14,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 var goRepoPath = map[string]bool{
@@ -145,6 +146,24 @@ func getPkgType(globalGoPath, projectRoot, name string) (PkgType, bool, error) {
 	return PkgTypeGloablGoPath, false, nil
 }
 
+// warnedImports are the imports through the global GOPATH which have been warned,
+// ListImports is called concurrently by the targets built or run in parallel
+var (
+	warnedImports     = make(map[string]bool)
+	warnedImportsLock sync.Mutex
+)
+
+// warnImportOnce returns true if the import has not been warned
+func warnImportOnce(key string) bool {
+	warnedImportsLock.Lock()
+	defer warnedImportsLock.Unlock()
+	if warnedImports[key] {
+		return false
+	}
+	warnedImports[key] = true
+	return true
+}
+
 type Pkg struct {
 	Name  string
 	Type  PkgType
@@ -155,6 +174,12 @@ type Pkg struct {
 func ListImports(gopath, importPath, projectRoot, srcPath, tags string, isTest bool) ([]Pkg, error) {
 	ctxt := build.Default
 	ctxt.BuildTags = strings.Split(tags, " ")
+	return ListImportsContext(ctxt, gopath, importPath, projectRoot, srcPath, isTest)
+}
+
+// ListImportsContext list all the dependencies packages name with the build context,
+// i.e. the files of another GOOS are selected when cross compiling
+func ListImportsContext(ctxt build.Context, gopath, importPath, projectRoot, srcPath string, isTest bool) ([]Pkg, error) {
 	ctxt.GOPATH = gopath
 
 	Printf("Import/root path: %s : %s\n", importPath, projectRoot)
@@ -182,6 +207,14 @@ func ListImports(gopath, importPath, projectRoot, srcPath, tags string, isTest b
 			continue
 		}
 
+		// the project packages are never copied to vendor even if they are imported through the global GOPATH
+		if projectPkg, ok := globalProjectImport(projectRoot, name); ok {
+			if warnImportOnce(importPath + " " + name) {
+				fmt.Fprintf(os.Stderr, "warning: %s imports %s through the global GOPATH, it's the project package %s\n", importPath, name, projectPkg)
+			}
+			name = projectPkg
+		}
+
 		pkgType, exist, err := getPkgType(oldGOPATH, projectRoot, name)
 		if err != nil {
 			return nil, err
@@ -197,7 +230,7 @@ func ListImports(gopath, importPath, projectRoot, srcPath, tags string, isTest b
 				Type: PkgTypeGloablGoPath,
 			})
 			if exist {
-				moreImports, err := ListImports(oldGOPATH, name, projectRoot, filepath.Join(oldGOPATH, "src"), tags, isTest)
+				moreImports, err := ListImportsContext(ctxt, oldGOPATH, name, projectRoot, filepath.Join(oldGOPATH, "src"), isTest)
 				if err != nil {
 					return nil, err
 				}
@@ -209,7 +242,7 @@ func ListImports(gopath, importPath, projectRoot, srcPath, tags string, isTest b
 				Type: PkgTypeProjectGoPath,
 			})
 			if exist {
-				moreImports, err := ListImports(projectRoot, name, projectRoot, filepath.Join(projectRoot, "src"), tags, isTest)
+				moreImports, err := ListImportsContext(ctxt, projectRoot, name, projectRoot, filepath.Join(projectRoot, "src"), isTest)
 				if err != nil {
 					return nil, err
 				}
@@ -221,7 +254,7 @@ func ListImports(gopath, importPath, projectRoot, srcPath, tags string, isTest b
 				Type: PkgTypeProjectVendor,
 			})
 			if exist {
-				moreImports, err := ListImports(projectRoot, name, projectRoot, filepath.Join(projectRoot, "src", "vendor"), tags, isTest)
+				moreImports, err := ListImportsContext(ctxt, projectRoot, name, projectRoot, filepath.Join(projectRoot, "src", "vendor"), isTest)
 				if err != nil {
 					return nil, err
 				}
@@ -0,0 +1,222 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// packageGraph is the import graph of the project packages and the vendored packages they import,
+// the nodes are the package directories
+type packageGraph struct {
+	srcDir     string
+	vendorDir  string
+	imports    map[string][]string
+	importedBy map[string][]string
+	// testedBy are the packages whose tests import the package
+	testedBy map[string][]string
+}
+
+// isProjectPackage returns true if the package directory is under src but not under src/vendor
+func (g *packageGraph) isProjectPackage(dir string) bool {
+	return dir != g.vendorDir && !strings.HasPrefix(dir, g.vendorDir+string(filepath.Separator))
+}
+
+// importPath returns the import path of the package directory
+func (g *packageGraph) importPath(dir string) string {
+	rel, _ := filepath.Rel(g.srcDir, dir)
+	return filepath.ToSlash(rel)
+}
+
+// loadPackageGraph walks all the project packages under src and resolves their imports
+// with the tags, only the imports in the project or vendored are kept
+func loadPackageGraph(projectRoot, tags string) (*packageGraph, error) {
+	ctxt := build.Default
+	ctxt.GOPATH = projectRoot
+	ctxt.BuildTags = strings.Fields(tags)
+
+	srcDir := filepath.Join(projectRoot, "src")
+	g := &packageGraph{
+		srcDir:     srcDir,
+		vendorDir:  filepath.Join(srcDir, "vendor"),
+		imports:    make(map[string][]string),
+		importedBy: make(map[string][]string),
+		testedBy:   make(map[string][]string),
+	}
+
+	var queue []string
+	err := filepath.Walk(srcDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if p != srcDir && (p == g.vendorDir || name == "testdata" ||
+			strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+		queue = append(queue, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var visited = make(map[string]bool)
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		if visited[dir] {
+			continue
+		}
+		visited[dir] = true
+
+		pkg, err := ctxt.ImportDir(dir, 0)
+		if err != nil {
+			// directories without go files are not packages
+			if _, ok := err.(*build.NoGoError); !ok {
+				Println("Import", dir, "failed:", err)
+			}
+			continue
+		}
+
+		g.imports[dir] = nil
+		for _, impDir := range g.resolve(&ctxt, dir, pkg.Imports) {
+			g.imports[dir] = append(g.imports[dir], impDir)
+			g.importedBy[impDir] = append(g.importedBy[impDir], dir)
+			queue = append(queue, impDir)
+		}
+
+		// the tests of the vendored packages are never run
+		if g.isProjectPackage(dir) {
+			for _, impDir := range g.resolve(&ctxt, dir, append(append([]string{}, pkg.TestImports...), pkg.XTestImports...)) {
+				g.testedBy[impDir] = append(g.testedBy[impDir], dir)
+				queue = append(queue, impDir)
+			}
+		}
+	}
+	return g, nil
+}
+
+// resolve returns the directories of the imports in the project or vendored
+func (g *packageGraph) resolve(ctxt *build.Context, dir string, imports []string) []string {
+	var dirs []string
+	var seen = make(map[string]bool)
+	for _, imp := range imports {
+		if imp == "C" || seen[imp] {
+			continue
+		}
+		seen[imp] = true
+
+		pkg, err := ctxt.Import(imp, dir, build.FindOnly)
+		if err != nil || pkg.Goroot || pkg.Dir == dir || !strings.HasPrefix(pkg.Dir, g.srcDir+string(filepath.Separator)) {
+			continue
+		}
+		dirs = append(dirs, pkg.Dir)
+	}
+	return dirs
+}
+
+// projectPackages returns the sorted import paths of all the project packages
+func (g *packageGraph) projectPackages() []string {
+	var pkgs []string
+	for dir := range g.imports {
+		if g.isProjectPackage(dir) {
+			pkgs = append(pkgs, g.importPath(dir))
+		}
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
+
+// reachable returns the directories reachable from dirs through edges, including dirs
+func reachable(dirs []string, edges map[string][]string) map[string]bool {
+	var visited = make(map[string]bool)
+	var queue = append([]string{}, dirs...)
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		if visited[dir] {
+			continue
+		}
+		visited[dir] = true
+		queue = append(queue, edges[dir]...)
+	}
+	return visited
+}
+
+// sortedPackages returns the sorted import paths of the project packages in dirs
+func (g *packageGraph) sortedPackages(dirs map[string]bool) []string {
+	var pkgs []string
+	for dir := range dirs {
+		if _, ok := g.imports[dir]; ok && g.isProjectPackage(dir) {
+			pkgs = append(pkgs, g.importPath(dir))
+		}
+	}
+	sort.Strings(pkgs)
+	return pkgs
+}
+
+// affected returns the project packages whose builds or tests are affected by the changes of dirs,
+// they are the packages in dirs, the packages importing them directly or indirectly and the packages
+// whose tests import any of them
+func (g *packageGraph) affected(dirs []string) []string {
+	visited := reachable(dirs, g.importedBy)
+	// the packages importing the tested packages are not affected, so only one step is taken
+	var affected = make(map[string]bool, len(visited))
+	for dir := range visited {
+		affected[dir] = true
+		for _, tested := range g.testedBy[dir] {
+			affected[tested] = true
+		}
+	}
+	return g.sortedPackages(affected)
+}
+
+// dependencies returns the project packages in dirs and the project packages they import directly or indirectly
+func (g *packageGraph) dependencies(dirs []string) []string {
+	return g.sortedPackages(reachable(dirs, g.imports))
+}
+
+// testDependencies returns the project packages built into the test binary of dir, they are the package
+// itself, the packages imported by it or by its tests, and their dependencies
+func (g *packageGraph) testDependencies(dir string) []string {
+	var dirs = []string{dir}
+	for dep, testers := range g.testedBy {
+		for _, tester := range testers {
+			if tester == dir {
+				dirs = append(dirs, dep)
+				break
+			}
+		}
+	}
+	return g.dependencies(dirs)
+}
+
+// selectPackages returns the project packages which are not excluded, if targetDir is not empty
+// only the packages of the target and its project dependencies are returned.
+// The exclude patterns are the globs matching the import paths.
+func (g *packageGraph) selectPackages(targetDir string, excludes []string) []string {
+	var pkgs []string
+	if targetDir != "" {
+		pkgs = g.dependencies([]string{filepath.Join(g.srcDir, targetDir)})
+	} else {
+		pkgs = g.projectPackages()
+	}
+
+	var selected = make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		if _, excluded := matchAnyGlob(excludes, pkg); !excluded {
+			selected = append(selected, pkg)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackageGraph(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), fmt.Sprintf("%d", time.Now().UnixNano()))
+	defer os.RemoveAll(tmpDir)
+
+	writeFile(t, tmpDir, "src/main/main.go", "package main\n\nimport \"routes\"\n\nfunc main() { routes.Do() }\n")
+	writeFile(t, tmpDir, "src/routes/routes.go", "package routes\n\nimport (\n\t\"fmt\"\n\t\"models\"\n)\n\nfunc Do() { fmt.Println(models.Name) }\n")
+	writeFile(t, tmpDir, "src/models/models.go", "package models\n\nimport \"github.com/a/b\"\n\nvar Name = b.B\n")
+	writeFile(t, tmpDir, "src/models/models_test.go", "package models\n\nimport \"testutil\"\n\nvar _ = testutil.T\n")
+	writeFile(t, tmpDir, "src/testutil/testutil.go", "package testutil\n\nvar T = 1\n")
+	writeFile(t, tmpDir, "src/other/other.go", "package other\n")
+	writeFile(t, tmpDir, "src/vendor/github.com/a/b/b.go", "package b\n\nvar B = \"b\"\n")
+
+	srcDir := filepath.Join(tmpDir, "src")
+	graph, err := loadPackageGraph(tmpDir, "")
+	assert.NoError(t, err)
+
+	assert.EqualValues(t, []string{"main", "models", "other", "routes", "testutil"}, graph.projectPackages())
+	assert.EqualValues(t, []string{"main", "models", "routes"}, graph.affected([]string{filepath.Join(srcDir, "models")}))
+	assert.EqualValues(t, []string{"main", "models", "routes"}, graph.affected([]string{filepath.Join(srcDir, "vendor", "github.com", "a", "b")}))
+	assert.EqualValues(t, []string{"models", "testutil"}, graph.affected([]string{filepath.Join(srcDir, "testutil")}))
+	assert.EqualValues(t, []string{"main", "models", "routes"}, graph.dependencies([]string{filepath.Join(srcDir, "main")}))
+
+	assert.EqualValues(t, []string{"models", "testutil"}, graph.testDependencies(filepath.Join(srcDir, "models")))
+	assert.EqualValues(t, []string{"main", "routes"}, graph.selectPackages("main", []string{"models"}))
+	assert.EqualValues(t, []string{"main", "models", "routes", "testutil"}, graph.selectPackages("", []string{"oth*"}))
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// newProcessGroupAttr starts the process as the leader of a new process group,
+// so that all the children of the process can be stopped together
+func newProcessGroupAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+func parseSignal(name string) (syscall.Signal, error) {
+	switch strings.TrimPrefix(strings.ToUpper(name), "SIG") {
+	case "", "TERM":
+		return syscall.SIGTERM, nil
+	case "INT":
+		return syscall.SIGINT, nil
+	case "QUIT":
+		return syscall.SIGQUIT, nil
+	case "HUP":
+		return syscall.SIGHUP, nil
+	case "KILL":
+		return syscall.SIGKILL, nil
+	}
+	return 0, fmt.Errorf("unsupported stop signal %s", name)
+}
+
+// signalGroup sends the signal to the process group, a group which has gone is not an error
+func signalGroup(pgid int, sig syscall.Signal) error {
+	if err := syscall.Kill(-pgid, sig); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	return nil
+}
+
+// isGroupAlive returns true if any process of the group is still running
+func isGroupAlive(pgid int) bool {
+	return syscall.Kill(-pgid, 0) == nil
+}
+
+// stopProcess sends the stop signal to the process group, and kills the group if it's
+// still alive after the grace period. The process is waited by the caller, exited should be
+// closed once the wait returns, so the exit state is kept by the caller.
+func stopProcess(p *os.Process, exited <-chan struct{}, signal string, grace time.Duration) error {
+	sig, err := parseSignal(signal)
+	if err != nil {
+		return err
+	}
+
+	if err = signalGroup(p.Pid, sig); err != nil {
+		return err
+	}
+
+	deadline := time.After(grace)
+	select {
+	case <-exited:
+		// wait the children of the process
+		for isGroupAlive(p.Pid) {
+			select {
+			case <-deadline:
+				fmt.Printf("=== Process group %d didn't exit in %s, killing it\n", p.Pid, grace)
+				return signalGroup(p.Pid, syscall.SIGKILL)
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+		return nil
+	case <-deadline:
+	}
+
+	fmt.Printf("=== Process %d didn't exit in %s, killing it\n", p.Pid, grace)
+	if err = signalGroup(p.Pid, syscall.SIGKILL); err != nil {
+		return err
+	}
+	<-exited
+	return nil
+}
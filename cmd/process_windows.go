@@ -0,0 +1,34 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// newProcessGroupAttr starts the process in a new process group
+func newProcessGroupAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// stopProcess kills the process tree, windows has no signals so the process cannot exit gracefully.
+// The process is waited by the caller, exited should be closed once the wait returns.
+func stopProcess(p *os.Process, exited <-chan struct{}, signal string, grace time.Duration) error {
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid)).Run(); err != nil {
+		if err = p.Kill(); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(grace):
+	}
+	return nil
+}
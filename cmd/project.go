@@ -5,12 +5,11 @@
 package cmd
 
 import (
-	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-
-	yaml "gopkg.in/yaml.v2"
+	"time"
 )
 
 // Target build target
@@ -19,16 +18,127 @@ type Target struct {
 	Dir      string
 	Assets   []string
 	Monitors []string
+	Image    ImageConfig
+	Run      RunConfig
+	Watch    WatchConfig
+}
+
+// WatchConfig the configuration of the files watched by gop run -w for a target,
+// all the glob patterns are relative to the src directory and could include **
+type WatchConfig struct {
+	// Include are the files which trigger rebuilding and restarting, default is **/*.go
+	Include []string `yaml:"include"`
+	// Exclude are the files which never trigger anything
+	Exclude []string `yaml:"exclude"`
+	// RestartOnly are the files which trigger restarting without rebuilding
+	RestartOnly []string `yaml:"restart_only"`
+	// IgnoreDirs are the directories which are not watched
+	IgnoreDirs []string `yaml:"ignore_dirs"`
+	// Delay is the quiet time after the last change before rebuilding, default is 300ms
+	Delay time.Duration `yaml:"delay"`
+}
+
+// RunConfig the configuration of gop run for a target
+type RunConfig struct {
+	// StopSignal is the signal sent to the old process on restarting, default is SIGTERM
+	StopSignal string `yaml:"stop_signal"`
+	// GracePeriod is the time to wait the old process exiting before killing it
+	GracePeriod time.Duration `yaml:"grace_period"`
+	// Port is the port the target listens on, the new process will be started after it's released
+	Port int `yaml:"port"`
+	// Args are the arguments passed to the program
+	Args []string `yaml:"args"`
+	// Env are the KEY=VALUE environment variables of the program
+	Env []string `yaml:"env"`
+	// EnvFile is the .env file loaded, relative to the target directory.
+	// If it's empty, the .env of the target directory or the project root will be loaded.
+	EnvFile string `yaml:"env_file"`
+	// WorkDir is the working directory of the program, relative to the target directory
+	WorkDir string `yaml:"workdir"`
+	// HealthCheck is the path requested by the live reload proxy to check whether the new process is ready, default is /
+	HealthCheck string `yaml:"health_check"`
+	// Restart is the restart policy when the process exits by itself in watch mode: never, on-failure or always
+	Restart string `yaml:"restart"`
+	// MaxRestarts is the max number of the restarts before the process is ready, 0 means no limit
+	MaxRestarts int `yaml:"max_restarts"`
+	// Backoff is the delay before the first restart, it's doubled on every restart until one minute. Default is 1s
+	Backoff time.Duration `yaml:"backoff"`
+	// ReadyCheck is the http:// URL or the tcp://host:port address checked to decide whether the process is ready.
+	// If it's empty, the process is ready after running 10 seconds.
+	ReadyCheck string `yaml:"ready_check"`
+	// ReadyTimeout is the time to wait the ready check passing, default is 30s
+	ReadyTimeout time.Duration `yaml:"ready_timeout"`
 }
 
 // Config gop.yml
 type Config struct {
 	Targets []Target
+	// Groups are the named lists of the targets which could be run together by gop run --group
+	Groups map[string][]string
+	// Coverage is the configuration of gop test --cover
+	Coverage CoverageConfig
+	// Vet is the configuration of the checks of gop vet
+	Vet VetConfig
+	// Generate is the configuration of gop generate
+	Generate GenerateConfig
+	// Bench is the configuration of gop bench
+	Bench BenchConfig
+	// Test is the configuration of gop test
+	Test TestConfig
+}
+
+// TestConfig the configuration of gop test
+type TestConfig struct {
+	// Quarantine are the tests as package.TestName whose failures don't fail gop test
+	Quarantine []string `yaml:"quarantine"`
+}
+
+// BenchConfig the configuration of gop bench
+type BenchConfig struct {
+	// Count is the number of the runs of every benchmark, default is 5
+	Count int `yaml:"count"`
+	// Threshold is the percentage of a significant regression compared with the baseline
+	// which makes gop bench fail, 0 means never failing
+	Threshold float64 `yaml:"threshold"`
+}
+
+// GenerateConfig the configuration of gop generate
+type GenerateConfig struct {
+	// Tools are the import paths of the commands built before generating and put on PATH,
+	// default are all the commands under src/tools
+	Tools []string `yaml:"tools"`
+}
+
+// VetConfig the configuration of gop vet
+type VetConfig struct {
+	// Checks are the enabled built-in checks: vet, missing-vendor, main-import and gopath, default is all of them
+	Checks []string `yaml:"checks"`
+	// Linters are the external linters run on the packages
+	Linters []LinterConfig `yaml:"linters"`
+	// Baseline is the file of the known issues which are not reported, default is vet-baseline.txt in the project root
+	Baseline string `yaml:"baseline"`
+}
+
+// LinterConfig an external linter of gop vet
+type LinterConfig struct {
+	// Name is the checker name shown with the issues
+	Name string `yaml:"name"`
+	// Command is the linter and its arguments, it runs in the src directory with the import paths of the packages appended.
+	// The issues are parsed from the file:line: message or file:line:column: message lines of the output.
+	Command []string `yaml:"command"`
+}
+
+// CoverageConfig the configuration of the coverage report of gop test --cover
+type CoverageConfig struct {
+	// Min is the minimum percentage of the total coverage, gop test --cover fails if the coverage is lower
+	Min float64 `yaml:"min"`
 }
 
 var config Config
 
 func loadConfig(ymlPath string) error {
+	// the config may be reloaded when gop.yml changed
+	config = Config{}
 	exist, _ := isFileExist(ymlPath)
 	if exist {
 		Println("Found config file", ymlPath)
@@ -37,8 +147,13 @@ func loadConfig(ymlPath string) error {
 			return err
 		}
 
-		err = yaml.Unmarshal(bs, &config)
-		if err != nil {
+		issues := parseConfig(filepath.Dir(ymlPath), bs, &config)
+		for _, issue := range issues {
+			if issue.Warning {
+				fmt.Fprintln(os.Stderr, issue)
+			}
+		}
+		if err = configErrors(issues); err != nil {
 			return err
 		}
 	}
@@ -62,39 +177,94 @@ const (
 	dirLevelTarget            // command run in <project root>/src/<target>
 )
 
-func analysisDirLevel() (int, string, error) {
+// ProjectFlag is the project root set by the global --project flag, it overrides GOP_PROJECT
+var ProjectFlag string
+
+// workDir returns the absolute working directory with the symlinks resolved
+func workDir() (string, error) {
 	wd, err := os.Getwd()
 	if err != nil {
-		return 0, "", err
+		return "", err
 	}
 
 	wd, err = filepath.Abs(wd)
 	if err != nil {
-		return 0, "", err
+		return "", err
 	}
+	return filepath.EvalSymlinks(wd)
+}
 
-	exist, _ := isFileExist(filepath.Join(wd, "gop.yml"))
-	if exist {
-		return dirLevelRoot, wd, nil
-	} else if filepath.Base(wd) == "src" {
-		exist, _ = isFileExist(filepath.Join(filepath.Dir(wd), "gop.yml"))
-		if exist {
-			return dirLevelSrc, filepath.Dir(wd), nil
+// isInDir returns true if p is dir or under dir
+func isInDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && !isOutsideDir(rel)
+}
+
+// findProjectRoot returns the project root and why it's chosen. It's set by --project or GOP_PROJECT,
+// otherwise it's the nearest directory with gop.yml from wd, so the nested projects are supported.
+func findProjectRoot(wd string) (string, string, error) {
+	var root, from = ProjectFlag, "--project"
+	if root == "" {
+		root, from = os.Getenv("GOP_PROJECT"), "GOP_PROJECT"
+	}
+	if root != "" {
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(wd, root)
+		}
+		resolved, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return "", "", fmt.Errorf("project %s set by %s: %v", root, from, err)
 		}
+		if exist, _ := isFileExist(filepath.Join(resolved, "gop.yml")); !exist {
+			return "", "", fmt.Errorf("project %s set by %s has no gop.yml", root, from)
+		}
+		return resolved, "set by " + from, nil
 	}
 
-	srcDir := filepath.Dir(wd)
-	for filepath.Base(srcDir) != "src" {
-		srcDir = filepath.Dir(srcDir)
-		if srcDir == "" || srcDir == "/" {
+	for dir := wd; ; dir = filepath.Dir(dir) {
+		if exist, _ := isFileExist(filepath.Join(dir, "gop.yml")); exist {
+			return dir, "the nearest directory with gop.yml from " + wd, nil
+		}
+		if filepath.Dir(dir) == dir {
 			break
 		}
 	}
+	return "", "", fmt.Errorf("no gop.yml in %s or its parent directories, run gop in a project or set --project or GOP_PROJECT", wd)
+}
+
+// analysisDirLevel returns the level of the working directory in the project and the project root
+func analysisDirLevel() (int, string, error) {
+	level, projectRoot, _, err := locateProject()
+	return level, projectRoot, err
+}
+
+// locateProject returns the level of the working directory in the project, the project root and
+// why the project root is chosen
+func locateProject() (int, string, string, error) {
+	wd, err := workDir()
+	if err != nil {
+		return 0, "", "", err
+	}
 
-	exist, _ = isFileExist(filepath.Join(filepath.Dir(srcDir), "gop.yml"))
-	if srcDir == "" || !exist {
-		return dirLevelOutProject, "", errors.New("unknow directory to run gop")
+	projectRoot, reason, err := findProjectRoot(wd)
+	if err != nil {
+		return dirLevelOutProject, "", "", err
 	}
+	reason = fmt.Sprintf("Project root %s is %s", projectRoot, reason)
+	if prefix, ok := projectInGoPath(projectRoot); ok {
+		reason += ", it's inside the global GOPATH as " + prefix
+	}
+	Println(reason)
 
-	return dirLevelTarget, filepath.Dir(srcDir), nil
+	srcDir := filepath.Join(projectRoot, "src")
+	switch {
+	case wd == srcDir:
+		return dirLevelSrc, projectRoot, reason, nil
+	case isInDir(srcDir, wd):
+		return dirLevelTarget, projectRoot, reason, nil
+	case isInDir(projectRoot, wd):
+		return dirLevelRoot, projectRoot, reason, nil
+	}
+	// the project is set by --project or GOP_PROJECT out of it
+	return dirLevelOutProject, projectRoot, reason, nil
 }
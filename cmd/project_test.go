@@ -0,0 +1,62 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindProjectRoot(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gop-project")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	tmpDir, err = filepath.EvalSymlinks(tmpDir)
+	assert.NoError(t, err)
+
+	outer := filepath.Join(tmpDir, "outer")
+	inner := filepath.Join(outer, "src", "tools", "inner")
+	writeFile(t, outer, "gop.yml", "")
+	writeFile(t, inner, "gop.yml", "")
+	writeFile(t, outer, "src/main/handlers/handlers.go", "package handlers\n")
+
+	os.Unsetenv("GOP_PROJECT")
+	root, _, err := findProjectRoot(filepath.Join(outer, "src", "main", "handlers"))
+	assert.NoError(t, err)
+	assert.EqualValues(t, outer, root)
+
+	// the nearest project is chosen
+	root, _, err = findProjectRoot(filepath.Join(inner, "src"))
+	assert.NoError(t, err)
+	assert.EqualValues(t, inner, root)
+
+	_, _, err = findProjectRoot(tmpDir)
+	assert.Error(t, err)
+
+	// the symlinks are resolved
+	link := filepath.Join(tmpDir, "link")
+	assert.NoError(t, os.Symlink(outer, link))
+	os.Setenv("GOP_PROJECT", "link")
+	defer os.Unsetenv("GOP_PROJECT")
+	root, reason, err := findProjectRoot(tmpDir)
+	assert.NoError(t, err)
+	assert.EqualValues(t, outer, root)
+	assert.EqualValues(t, "set by GOP_PROJECT", reason)
+
+	ProjectFlag = inner
+	defer func() { ProjectFlag = "" }()
+	root, reason, err = findProjectRoot(tmpDir)
+	assert.NoError(t, err)
+	assert.EqualValues(t, inner, root)
+	assert.EqualValues(t, "set by --project", reason)
+
+	ProjectFlag = filepath.Join(outer, "src")
+	_, _, err = findProjectRoot(tmpDir)
+	assert.Error(t, err)
+}
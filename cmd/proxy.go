@@ -0,0 +1,248 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	liveReloadEventsPath = "/__gop/livereload"
+	liveReloadScriptPath = "/__gop/livereload.js"
+	liveReloadScript     = `(function() {
+	var source = new EventSource("` + liveReloadEventsPath + `");
+	source.addEventListener("reload", function() { window.location.reload(); });
+})();
+`
+	// proxyHoldTimeout is the longest time a request is held while the target is rebuilding
+	proxyHoldTimeout = 2 * time.Minute
+	// healthCheckTimeout is the longest time to wait the new process answering the health check
+	healthCheckTimeout = 30 * time.Second
+)
+
+var liveReloadTag = []byte(`<script src="` + liveReloadScriptPath + `"></script>`)
+
+// injectLiveReload inserts the live reload script before </body>, or appends it if there is no </body>
+func injectLiveReload(body []byte) []byte {
+	i := bytes.LastIndex(bytes.ToLower(body), []byte("</body>"))
+	if i < 0 {
+		i = len(body)
+	}
+
+	result := make([]byte, 0, len(body)+len(liveReloadTag))
+	result = append(result, body[:i]...)
+	result = append(result, liveReloadTag...)
+	return append(result, body[i:]...)
+}
+
+// liveReloadProxy is a reverse proxy in front of the target, it holds the requests while the target
+// is restarting and tells the browsers to reload after the new process is ready
+type liveReloadProxy struct {
+	port        int
+	healthCheck string
+	proxy       *httputil.ReverseProxy
+
+	lock    sync.Mutex
+	ready   chan struct{}
+	clients map[chan struct{}]bool
+	// holds is the number of the hold calls, a release is ignored if a newer hold is called
+	holds int
+}
+
+// newLiveReloadProxy creates the proxy to the port, the requests are held until release is called
+func newLiveReloadProxy(port int, healthCheck string) *liveReloadProxy {
+	if healthCheck == "" {
+		healthCheck = "/"
+	}
+
+	p := &liveReloadProxy{
+		port:        port,
+		healthCheck: healthCheck,
+		ready:       make(chan struct{}),
+		clients:     make(map[chan struct{}]bool),
+	}
+
+	p.proxy = httputil.NewSingleHostReverseProxy(&url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("127.0.0.1:%d", port),
+	})
+	director := p.proxy.Director
+	p.proxy.Director = func(req *http.Request) {
+		director(req)
+		// the script cannot be injected into compressed responses
+		req.Header.Del("Accept-Encoding")
+	}
+	p.proxy.ModifyResponse = p.modifyResponse
+	return p
+}
+
+func (p *liveReloadProxy) modifyResponse(resp *http.Response) error {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	body = injectLiveReload(body)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// hold makes the new requests wait until release is called
+func (p *liveReloadProxy) hold() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.holds++
+	select {
+	case <-p.ready:
+		p.ready = make(chan struct{})
+	default:
+	}
+}
+
+// release lets the held requests go. If restarted is true, it waits the new process answering
+// the health check and then tells the browsers to reload.
+func (p *liveReloadProxy) release(restarted bool) {
+	p.lock.Lock()
+	holds := p.holds
+	p.lock.Unlock()
+
+	if restarted && !p.waitHealthy(healthCheckTimeout) {
+		fmt.Printf("=== No answer of the health check %s in %s\n", p.healthCheck, healthCheckTimeout)
+		restarted = false
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.holds != holds {
+		return
+	}
+
+	select {
+	case <-p.ready:
+	default:
+		close(p.ready)
+	}
+
+	if restarted {
+		for c := range p.clients {
+			select {
+			case c <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// waitHealthy returns true once the health check gets a response which is not a server error
+func (p *liveReloadProxy) waitHealthy(timeout time.Duration) bool {
+	client := &http.Client{Timeout: time.Second}
+	healthURL := fmt.Sprintf("http://127.0.0.1:%d%s", p.port, p.healthCheck)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		resp, err := client.Get(healthURL)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < http.StatusInternalServerError {
+				return true
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
+
+func (p *liveReloadProxy) serveEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	c := make(chan struct{}, 1)
+	p.lock.Lock()
+	p.clients[c] = true
+	p.lock.Unlock()
+	defer func() {
+		p.lock.Lock()
+		delete(p.clients, c)
+		p.lock.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-c:
+			fmt.Fprint(w, "event: reload\ndata: reload\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func (p *liveReloadProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case liveReloadEventsPath:
+		p.serveEvents(w, req)
+		return
+	case liveReloadScriptPath:
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Write([]byte(liveReloadScript))
+		return
+	}
+
+	p.lock.Lock()
+	ready := p.ready
+	p.lock.Unlock()
+
+	select {
+	case <-ready:
+	case <-req.Context().Done():
+		return
+	case <-time.After(proxyHoldTimeout):
+		http.Error(w, "the target is not ready", http.StatusGatewayTimeout)
+		return
+	}
+	p.proxy.ServeHTTP(w, req)
+}
+
+// listen starts serving the proxy on addr in the background
+func (p *liveReloadProxy) listen(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("live reload proxy: %v", err)
+	}
+
+	fmt.Printf("=== Live reload proxy listening on %s, forwarding to port %d\n", addr, p.port)
+	go func() {
+		if err := http.Serve(ln, p); err != nil {
+			log.Println("Live reload proxy error:", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInjectLiveReload(t *testing.T) {
+	assert.Equal(t, `<html><BODY>hi`+string(liveReloadTag)+`</BODY></html>`,
+		string(injectLiveReload([]byte(`<html><BODY>hi</BODY></html>`))))
+	assert.Equal(t, `hi`+string(liveReloadTag), string(injectLiveReload([]byte(`hi`))))
+}
+
+func TestLiveReloadProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<body>hello</body>"))
+	}))
+	defer backend.Close()
+
+	_, port, err := net.SplitHostPort(backend.Listener.Addr().String())
+	assert.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	p := newLiveReloadProxy(portNum, "")
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	// the requests are held until the target is ready
+	result := make(chan string)
+	go func() {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		bs, _ := ioutil.ReadAll(resp.Body)
+		result <- string(bs)
+	}()
+
+	select {
+	case <-result:
+		t.Fatal("the request should be held")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	p.release(true)
+	assert.Equal(t, "<body>hello"+string(liveReloadTag)+"</body>", <-result)
+}
+
+func TestLiveReloadProxyListen(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	// the address in use is reported instead of logged later
+	p := newLiveReloadProxy(0, "")
+	assert.Error(t, p.listen(ln.Addr().String()))
+}
@@ -5,11 +5,13 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
-	"strings"
+	"time"
 
 	"github.com/Unknwon/com"
 	"github.com/urfave/cli"
@@ -24,6 +26,58 @@ var CmdRelease = cli.Command{
 	SkipFlagParsing: true,
 }
 
+// releaseBuild builds the target to exePath with the environment variables
+func releaseBuild(projectRoot string, target *Target, args []string, exePath string, envs []string) error {
+	args = append(args, "-o", exePath)
+	cmd := NewCommand("build").AddArguments(args...)
+	cmd.Env = envs
+	return cmd.RunInDirPipeline(filepath.Join(projectRoot, "src", target.Dir), os.Stdout, os.Stderr)
+}
+
+// verifyRelease builds the target twice into temporary directories and checks whether the results are the same
+func verifyRelease(projectRoot string, target *Target, args []string, ext string, envs []string, epoch time.Time) error {
+	var dirs [2]string
+	for i := range dirs {
+		dir, err := ioutil.TempDir(os.TempDir(), "gop-release")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		fmt.Printf("=== Building %s into %s\n", target.Name, dir)
+		// every build uses an empty build cache, otherwise the second build will be copied from the cache
+		buildEnvs := append(append([]string{}, envs...), "GOCACHE="+filepath.Join(dir, ".cache"))
+		dirs[i] = filepath.Join(dir, target.Name)
+		if err = releaseBuild(projectRoot, target, args, filepath.Join(dirs[i], target.Name+ext), buildEnvs); err != nil {
+			return err
+		}
+		if err = copyAssets(projectRoot, target, dirs[i]); err != nil {
+			return err
+		}
+		if err = normalizeDir(dirs[i], epoch); err != nil {
+			return err
+		}
+	}
+
+	diffs, err := compareDirs(dirs[0], dirs[1])
+	if err != nil {
+		return err
+	}
+	if len(diffs) > 0 {
+		for _, diff := range diffs {
+			fmt.Println("differ:", diff)
+		}
+		return fmt.Errorf("release of %s is not reproducible, %d files differ", target.Name, len(diffs))
+	}
+
+	h, err := hashFile(filepath.Join(dirs[0], target.Name+ext))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Release of %s is reproducible, sha256: %s\n", target.Name, h)
+	return nil
+}
+
 func runRelease(ctx *cli.Context) error {
 	_, projectRoot, err := analysisDirLevel()
 	if err != nil {
@@ -35,7 +89,27 @@ func runRelease(ctx *cli.Context) error {
 	}
 
 	var target = config.Targets[0]
-	var args = ctx.Args()
+	var args = make([]string, 0, len(ctx.Args()))
+	var forceFlag, reproducibleFlag, verifyFlag, imageFlag bool
+	for _, arg := range ctx.Args() {
+		switch arg {
+		case "--force":
+			forceFlag = true
+		case "--reproducible":
+			reproducibleFlag = true
+		case "--verify":
+			verifyFlag = true
+		case "--image":
+			imageFlag = true
+		default:
+			args = append(args, arg)
+		}
+	}
+
+	if imageFlag && verifyFlag {
+		return errors.New("--image can't be used with --verify")
+	}
+
 	var find = -1
 	for i, arg := range args {
 		if arg == "-v" {
@@ -53,50 +127,102 @@ func runRelease(ctx *cli.Context) error {
 		}
 	}
 
+	var goos, goarch string
+	if imageFlag {
+		// the binary in the image should be a static linux binary
+		if goos, goarch, err = imageGoEnv(); err != nil {
+			return err
+		}
+	}
+
 	var ext string
-	if os.Getenv("GOOS") == "windows" ||
-		(os.Getenv("GOOS") == "" && runtime.GOOS == "windows") {
+	if !imageFlag && (os.Getenv("GOOS") == "windows" ||
+		(os.Getenv("GOOS") == "" && runtime.GOOS == "windows")) {
 		ext = ".exe"
 	}
 
-	args = append(args, "-o", filepath.Join(projectRoot, "bin", target.Name, target.Name+ext))
-	cmd := NewCommand("build").AddArguments(args...)
-	envs := os.Environ()
-	var gopathIdx = -1
-	for i, env := range envs {
-		if strings.HasPrefix(env, "GOPATH=") {
-			gopathIdx = i
-			break
+	envs := projectEnv(projectRoot)
+	if imageFlag {
+		envs = setEnv(envs, "GOOS", goos)
+		envs = setEnv(envs, "GOARCH", goarch)
+		envs = setEnv(envs, "CGO_ENABLED", "0")
+	}
+
+	var epoch time.Time
+	if reproducibleFlag || verifyFlag {
+		if epoch, err = sourceDateEpoch(projectRoot); err != nil {
+			return err
 		}
+		if args, err = reproducibleArgs(args, projectRoot); err != nil {
+			return err
+		}
+		envs = append(envs, fmt.Sprintf("SOURCE_DATE_EPOCH=%d", epoch.Unix()))
 	}
 
-	newGopath := fmt.Sprintf("GOPATH=%s", projectRoot)
-	if gopathIdx > 0 {
-		envs[gopathIdx] = newGopath
-	} else {
-		envs = append(envs, newGopath)
+	if verifyFlag {
+		return verifyRelease(projectRoot, &target, args, ext, envs, epoch)
 	}
-	cmd.Env = envs
 
-	err = cmd.RunInDirPipeline(filepath.Join(projectRoot, "src", target.Dir), os.Stdout, os.Stderr)
+	releaseDir := filepath.Join(projectRoot, "bin", target.Name)
+	if imageFlag {
+		// the linux binary is staged in its own directory, so the release for the host is kept
+		releaseDir = filepath.Join(projectRoot, "bin", ".image", target.Name)
+	}
+	exePath := filepath.Join(releaseDir, target.Name+ext)
+
+	fingerprint, err := targetFingerprint(projectRoot, &target, append(args, "-o", exePath), envs)
 	if err != nil {
+		Println("Compute fingerprint failed:", err)
+	} else if !forceFlag && isUpToDate(exePath, fingerprint) {
+		fmt.Println(target.Name, "is up to date")
+	} else {
+		if err = releaseBuild(projectRoot, &target, args, exePath, envs); err != nil {
+			return err
+		}
+
+		if fingerprint != "" {
+			if err = saveFingerprint(exePath, fingerprint); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = copyAssets(projectRoot, &target, releaseDir); err != nil {
 		return err
 	}
 
+	if reproducibleFlag {
+		if err = normalizeDir(releaseDir, epoch); err != nil {
+			return err
+		}
+	}
+
+	if imageFlag {
+		var mtime = epoch
+		if !reproducibleFlag {
+			mtime = time.Now()
+		}
+		return releaseImage(projectRoot, &target, releaseDir, goos, goarch, mtime)
+	}
+	return nil
+}
+
+// copyAssets copies the target's assets to the release directory
+func copyAssets(projectRoot string, target *Target, releaseDir string) error {
 	for _, asset := range target.Assets {
 		srcPath := filepath.Join(projectRoot, "src", target.Dir, asset)
-		dstPath := filepath.Join(projectRoot, "bin", target.Name, asset)
+		dstPath := filepath.Join(releaseDir, asset)
 		exist, _ := isDirExist(srcPath)
 		fileExist, _ := isFileExist(srcPath)
 		if exist {
 			os.RemoveAll(dstPath)
-			err = com.CopyDir(srcPath, dstPath)
+			err := com.CopyDir(srcPath, dstPath)
 			if err != nil {
 				Errorf("copy dir %s to %s failed: %v\n", srcPath, dstPath, err)
 			}
 		} else if fileExist {
 			os.RemoveAll(dstPath)
-			err = com.Copy(srcPath, dstPath)
+			err := com.Copy(srcPath, dstPath)
 			if err != nil {
 				Errorf("copy file %s to %s failed: %v\n", srcPath, dstPath, err)
 			}
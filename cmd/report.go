@@ -0,0 +1,469 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the formats of gop test --report
+const (
+	reportJUnit = "junit"
+	reportJSON  = "json"
+)
+
+// the status of the packages and the tests in the reports,
+// a package is errored if it cannot be built
+const (
+	testPass  = "pass"
+	testFail  = "fail"
+	testSkip  = "skip"
+	testError = "error"
+)
+
+var (
+	// testCaseResultRegexp matches the result lines of go test -v, i.e. --- PASS: TestUser (0.01s)
+	testCaseResultRegexp = regexp.MustCompile(`^\s*--- (PASS|FAIL|SKIP): (\S+) \(([\d.]+)s\)`)
+	// testCaseStartRegexp matches the lines of go test -v when a test starts or continues
+	testCaseStartRegexp = regexp.MustCompile(`^=== (?:RUN|CONT|PAUSE|NAME)\s+(\S+)`)
+	// testElapsedRegexp matches the elapsed time of a package, i.e. 0.012s
+	testElapsedRegexp = regexp.MustCompile(`^([\d.]+)s`)
+)
+
+// testCaseReport is the result of a test function
+type testCaseReport struct {
+	Name    string  `json:"name"`
+	Status  string  `json:"status"`
+	Elapsed float64 `json:"elapsed"`
+	Output  string  `json:"output,omitempty"`
+	// Retries is the number of the reruns after the test failed
+	Retries int `json:"retries,omitempty"`
+	// Flaky is true if the test failed but passed in a rerun
+	Flaky bool `json:"flaky,omitempty"`
+	// Quarantined is true if the test is quarantined in gop.yml, its failures are ignored
+	Quarantined bool `json:"quarantined,omitempty"`
+}
+
+// packageReport is the result of the tests of a package, Error is the build output if the package cannot be built
+type packageReport struct {
+	Package string            `json:"package"`
+	Status  string            `json:"status"`
+	Elapsed float64           `json:"elapsed"`
+	Error   string            `json:"error,omitempty"`
+	Tests   []*testCaseReport `json:"tests"`
+}
+
+// count returns the number of the tests of the status
+func (r *packageReport) count(status string) int {
+	var n int
+	for _, t := range r.Tests {
+		if t.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// parseReportFlag parses the value of --report, i.e. junit=report.xml,json=report.json
+func parseReportFlag(value string) (map[string]string, error) {
+	var reports = make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("bad report %s, it should be format=path", part)
+		}
+		switch kv[0] {
+		case reportJUnit, reportJSON:
+			reports[kv[0]] = kv[1]
+		default:
+			return nil, fmt.Errorf("unknown report format %s, it should be %s or %s", kv[0], reportJUnit, reportJSON)
+		}
+	}
+	return reports, nil
+}
+
+// buildOutputs collects the build failures in the output of go test, they are under the # <package> lines
+type buildOutputs struct {
+	current string
+	outputs map[string]string
+}
+
+// add returns false if the line is not a part of the build failures
+func (b *buildOutputs) add(line string) bool {
+	if strings.HasPrefix(line, "# ") {
+		// i.e. # models [models.test]
+		b.current = strings.Fields(line[2:])[0]
+		return true
+	}
+	if b.current == "" || (!buildErrorRegexp.MatchString(line) && !strings.HasPrefix(line, "\t")) {
+		b.current = ""
+		return false
+	}
+	if b.outputs == nil {
+		b.outputs = make(map[string]string)
+	}
+	b.outputs[b.current] += line + "\n"
+	return true
+}
+
+// finishPackage sets the status of the package from the result line of go test
+func finishPackage(pkg *packageReport, status, detail string, builds *buildOutputs) {
+	switch {
+	case strings.Contains(detail, "[build failed]") || strings.Contains(detail, "[setup failed]"):
+		pkg.Status = testError
+		pkg.Error = builds.outputs[pkg.Package]
+		if pkg.Error == "" {
+			pkg.Error = detail
+		}
+	case status == "FAIL":
+		pkg.Status = testFail
+	case status == "ok":
+		pkg.Status = testPass
+	default:
+		pkg.Status = testSkip
+	}
+	if matches := testElapsedRegexp.FindStringSubmatch(detail); matches != nil {
+		pkg.Elapsed, _ = strconv.ParseFloat(matches[1], 64)
+	}
+}
+
+// parseTestVerbose parses the output of go test -v. The output of a test is the lines printed
+// while it's running, and the indented lines following its result.
+func parseTestVerbose(output []byte) []*packageReport {
+	var (
+		pkgs     []*packageReport
+		tests    []*testCaseReport
+		outputs  = make(map[string]string)
+		current  string
+		last     *testCaseReport
+		builds   buildOutputs
+		statuses = map[string]string{"PASS": testPass, "FAIL": testFail, "SKIP": testSkip}
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if builds.add(line) {
+			continue
+		}
+
+		if matches := testCaseStartRegexp.FindStringSubmatch(line); matches != nil {
+			current, last = matches[1], nil
+			continue
+		}
+		if matches := testCaseResultRegexp.FindStringSubmatch(line); matches != nil {
+			last = &testCaseReport{
+				Name:   matches[2],
+				Status: statuses[matches[1]],
+				Output: outputs[matches[2]],
+			}
+			last.Elapsed, _ = strconv.ParseFloat(matches[3], 64)
+			tests = append(tests, last)
+			delete(outputs, matches[2])
+			current = ""
+			continue
+		}
+		if matches := testResultRegexp.FindStringSubmatch(line); matches != nil {
+			pkg := &packageReport{Package: matches[2], Tests: tests}
+			finishPackage(pkg, matches[1], matches[3], &builds)
+			pkgs = append(pkgs, pkg)
+			tests, last, current = nil, nil, ""
+			outputs = make(map[string]string)
+			continue
+		}
+		if line == "PASS" || line == "FAIL" {
+			continue
+		}
+
+		if last != nil && (strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")) {
+			last.Output += line + "\n"
+		} else if current != "" {
+			outputs[current] += line + "\n"
+		}
+	}
+	return pkgs
+}
+
+// testEvent is an event of go test -json
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+	// ImportPath is the package of the build-output events since go 1.24
+	ImportPath string
+}
+
+// decodeTestEvents decodes the events of go test -json, the lines which are not events
+// are returned as the output events without a package
+func decodeTestEvents(output []byte) []testEvent {
+	var events []testEvent
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var event testEvent
+		if len(line) == 0 || line[0] != '{' || json.Unmarshal(line, &event) != nil {
+			event = testEvent{Action: "output", Output: string(line) + "\n"}
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// parseTestOutput parses the results of the tests from the output of go test -json or go test -v,
+// the text output is also returned
+func parseTestOutput(output []byte, jsonOutput bool) ([]byte, []*packageReport) {
+	if jsonOutput {
+		events := decodeTestEvents(output)
+		return testEventsOutput(events), parseTestJSON(events)
+	}
+	return output, parseTestVerbose(output)
+}
+
+// testEventsOutput returns the text output of the events, it's the same as the output of go test -v
+func testEventsOutput(events []testEvent) []byte {
+	var buf bytes.Buffer
+	for _, event := range events {
+		if event.Action == "output" || event.Action == "build-output" {
+			buf.WriteString(event.Output)
+		}
+	}
+	return buf.Bytes()
+}
+
+// parseTestJSON parses the events of go test -json
+func parseTestJSON(events []testEvent) []*packageReport {
+	var (
+		pkgs   []*packageReport
+		byName = make(map[string]*packageReport)
+		tests  = make(map[string]*testCaseReport)
+		builds buildOutputs
+	)
+
+	getPackage := func(name string) *packageReport {
+		pkg, ok := byName[name]
+		if !ok {
+			pkg = &packageReport{Package: name}
+			byName[name] = pkg
+			pkgs = append(pkgs, pkg)
+		}
+		return pkg
+	}
+
+	for _, event := range events {
+		if event.Action == "build-output" {
+			builds.add("# " + event.ImportPath)
+			builds.add(strings.TrimSuffix(event.Output, "\n"))
+			continue
+		}
+		if event.Package == "" {
+			builds.add(strings.TrimSuffix(event.Output, "\n"))
+			continue
+		}
+
+		pkg := getPackage(event.Package)
+		if event.Test == "" {
+			switch event.Action {
+			case "output":
+				if matches := testResultRegexp.FindStringSubmatch(strings.TrimSuffix(event.Output, "\n")); matches != nil {
+					finishPackage(pkg, matches[1], matches[3], &builds)
+				}
+			case "pass", "fail", "skip":
+				if pkg.Status != testError {
+					pkg.Status = event.Action
+				}
+				pkg.Elapsed = event.Elapsed
+			}
+			continue
+		}
+
+		key := event.Package + " " + event.Test
+		test, ok := tests[key]
+		if !ok {
+			test = &testCaseReport{Name: event.Test}
+			tests[key] = test
+			pkg.Tests = append(pkg.Tests, test)
+		}
+		switch event.Action {
+		case "output":
+			if !testCaseStartRegexp.MatchString(event.Output) && !testCaseResultRegexp.MatchString(event.Output) {
+				test.Output += event.Output
+			}
+		case "pass", "fail", "skip":
+			test.Status = event.Action
+			test.Elapsed = event.Elapsed
+		}
+	}
+	return pkgs
+}
+
+// testJSONWriter writes the text output of the events of go test -json written to it
+type testJSONWriter struct {
+	w    io.Writer
+	line []byte
+}
+
+func newTestJSONWriter(w io.Writer) *testJSONWriter {
+	return &testJSONWriter{w: w}
+}
+
+func (t *testJSONWriter) Write(p []byte) (int, error) {
+	t.line = append(t.line, p...)
+	for {
+		i := bytes.IndexByte(t.line, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		events := decodeTestEvents(t.line[:i+1])
+		t.line = t.line[i+1:]
+		if _, err := t.w.Write(testEventsOutput(events)); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+// writeJUnitReport writes the packages as the test suites of JUnit XML,
+// a package which cannot be built is reported as a test case with an error
+func writeJUnitReport(w io.Writer, pkgs []*packageReport) error {
+	var suites junitTestSuites
+	for _, pkg := range pkgs {
+		suite := junitTestSuite{
+			Name:    pkg.Package,
+			Tests:   len(pkg.Tests),
+			Skipped: pkg.count(testSkip),
+			Time:    fmt.Sprintf("%.3f", pkg.Elapsed),
+		}
+		for _, t := range pkg.Tests {
+			c := junitTestCase{
+				ClassName: pkg.Package,
+				Name:      t.Name,
+				Time:      fmt.Sprintf("%.3f", t.Elapsed),
+			}
+			// the failures of the quarantined tests are reported as skipped
+			switch {
+			case t.Status == testFail && t.Quarantined:
+				suite.Skipped++
+				c.Skipped = &junitMessage{Message: "Quarantined", Content: t.Output}
+			case t.Status == testFail:
+				suite.Failures++
+				c.Failure = &junitMessage{Message: "Failed", Content: t.Output}
+			case t.Status == testSkip:
+				c.Skipped = &junitMessage{Message: "Skipped", Content: t.Output}
+			case t.Flaky:
+				c.SystemOut = fmt.Sprintf("flaky: passed after %d retries\n%s", t.Retries, t.Output)
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+		if pkg.Status == testError {
+			suite.Tests++
+			suite.Errors++
+			suite.Cases = append(suite.Cases, junitTestCase{
+				ClassName: pkg.Package,
+				Name:      "build",
+				Time:      "0.000",
+				Error:     &junitMessage{Message: "Build failed", Content: pkg.Error},
+			})
+		}
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeTestReports writes the reports of the formats to the paths, the relative paths are relative to projectRoot
+func writeTestReports(projectRoot string, reports map[string]string, pkgs []*packageReport, elapsed time.Duration) error {
+	if pkgs == nil {
+		pkgs = []*packageReport{}
+	}
+	for _, pkg := range pkgs {
+		if pkg.Tests == nil {
+			pkg.Tests = []*testCaseReport{}
+		}
+	}
+
+	for format, p := range reports {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(projectRoot, p)
+		}
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		switch format {
+		case reportJUnit:
+			if err := writeJUnitReport(&buf, pkgs); err != nil {
+				return err
+			}
+		case reportJSON:
+			bs, err := json.MarshalIndent(struct {
+				Elapsed  float64          `json:"elapsed"`
+				Packages []*packageReport `json:"packages"`
+			}{elapsed.Seconds(), pkgs}, "", "  ")
+			if err != nil {
+				return err
+			}
+			buf.Write(append(bs, '\n'))
+		}
+		if err := ioutil.WriteFile(p, buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("=== The %s report is written to %s\n", format, p)
+	}
+	return nil
+}
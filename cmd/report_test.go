@@ -0,0 +1,78 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReportFlag(t *testing.T) {
+	reports, err := parseReportFlag("junit=bin/report.xml,json=bin/report.json")
+	assert.NoError(t, err)
+	assert.EqualValues(t, map[string]string{"junit": "bin/report.xml", "json": "bin/report.json"}, reports)
+
+	_, err = parseReportFlag("html=report.html")
+	assert.Error(t, err)
+	_, err = parseReportFlag("junit")
+	assert.Error(t, err)
+}
+
+func TestParseTestVerbose(t *testing.T) {
+	pkgs := parseTestVerbose([]byte(`=== RUN   TestUser
+--- FAIL: TestUser (0.01s)
+	user_test.go:10: wrong name
+=== RUN   TestSkip
+--- SKIP: TestSkip (0.00s)
+	user_test.go:20: later
+FAIL
+FAIL	models	0.013s
+# routes
+routes/api_test.go:5:2: undefined: foo
+FAIL	routes [build failed]
+?   	main	[no test files]
+`))
+
+	assert.EqualValues(t, []*packageReport{
+		{Package: "models", Status: testFail, Elapsed: 0.013, Tests: []*testCaseReport{
+			{Name: "TestUser", Status: testFail, Elapsed: 0.01, Output: "\tuser_test.go:10: wrong name\n"},
+			{Name: "TestSkip", Status: testSkip, Output: "\tuser_test.go:20: later\n"},
+		}},
+		{Package: "routes", Status: testError, Error: "routes/api_test.go:5:2: undefined: foo\n"},
+		{Package: "main", Status: testSkip},
+	}, pkgs)
+}
+
+func TestParseTestJSON(t *testing.T) {
+	events := decodeTestEvents([]byte(`{"Action":"run","Package":"models","Test":"TestUser"}
+{"Action":"output","Package":"models","Test":"TestUser","Output":"=== RUN   TestUser\n"}
+{"Action":"output","Package":"models","Test":"TestUser","Output":"    user_test.go:10: wrong name\n"}
+{"Action":"output","Package":"models","Test":"TestUser","Output":"--- FAIL: TestUser (0.01s)\n"}
+{"Action":"fail","Package":"models","Test":"TestUser","Elapsed":0.01}
+{"Action":"output","Package":"models","Output":"FAIL\tmodels\t0.013s\n"}
+{"Action":"fail","Package":"models","Elapsed":0.013}
+# routes
+routes/api_test.go:5:2: undefined: foo
+{"Action":"output","Package":"routes","Output":"FAIL\troutes [build failed]\n"}
+{"Action":"fail","Package":"routes","Elapsed":0}
+`))
+
+	assert.EqualValues(t, []*packageReport{
+		{Package: "models", Status: testFail, Elapsed: 0.013, Tests: []*testCaseReport{
+			{Name: "TestUser", Status: testFail, Elapsed: 0.01, Output: "    user_test.go:10: wrong name\n"},
+		}},
+		{Package: "routes", Status: testError, Error: "routes/api_test.go:5:2: undefined: foo\n"},
+	}, parseTestJSON(events))
+
+	assert.True(t, strings.HasPrefix(string(testEventsOutput(events)), "=== RUN   TestUser\n    user_test.go:10"))
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeJUnitReport(&buf, parseTestJSON(events)))
+	assert.Contains(t, buf.String(), `<testsuite name="routes" tests="1" failures="0" errors="1" skipped="0" time="0.000">`)
+	assert.Contains(t, buf.String(), `<failure message="Failed">`)
+}
@@ -0,0 +1,228 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// goVersionAtLeast returns true if the go version is newer than or equal to major.minor,
+// unknown versions such as devel builds are treated as the newest.
+func goVersionAtLeast(version string, major, minor int) bool {
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return true
+	}
+	ma, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return true
+	}
+	// the minor version may have a suffix, i.e. 1.10beta1, 1.12rc1
+	var i int
+	for i < len(parts[1]) && parts[1][i] >= '0' && parts[1][i] <= '9' {
+		i++
+	}
+	mi, err := strconv.Atoi(parts[1][:i])
+	if err != nil {
+		return true
+	}
+	return ma > major || (ma == major && mi >= minor)
+}
+
+// sourceDateEpoch returns the timestamp used for reproducible builds, it's from the
+// SOURCE_DATE_EPOCH environment variable or the last commit time of the project.
+func sourceDateEpoch(projectRoot string) (time.Time, error) {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		sec, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %s: %v", epoch, err)
+		}
+		return time.Unix(sec, 0).UTC(), nil
+	}
+
+	cmd := exec.Command("git", "log", "-1", "--format=%ct")
+	cmd.Dir = projectRoot
+	bs, err := cmd.Output()
+	if err != nil {
+		return time.Time{}, errors.New("cannot get the last commit time, please set SOURCE_DATE_EPOCH")
+	}
+	sec, err := strconv.ParseInt(strings.TrimSpace(string(bs)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid last commit time %s: %v", bs, err)
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+// mergeFlag appends value to every go build flag name, i.e. -ldflags, if the flag is not given
+// it will be added with the package pattern. Since go1.10 a value without pattern only applies to
+// the main packages, so the given values should have the same pattern, otherwise they are rejected
+// instead of changing the packages they apply to.
+func mergeFlag(args []string, name, pattern, value string) ([]string, error) {
+	var found bool
+	for i, arg := range args {
+		idx := -1
+		if arg == name && i < len(args)-1 {
+			idx = i + 1
+		} else if strings.HasPrefix(arg, name+"=") {
+			idx = i
+		}
+		if idx < 0 {
+			continue
+		}
+
+		given := strings.TrimPrefix(args[idx], name+"=")
+		if !strings.HasPrefix(given, pattern) {
+			return nil, fmt.Errorf("%s %s conflicts with %s%s added by --reproducible, please give it as %s%s",
+				name, given, pattern, value, pattern, given)
+		}
+		args[idx] += " " + value
+		found = true
+	}
+	if found {
+		return args, nil
+	}
+	return append(args, name, pattern+value), nil
+}
+
+// reproducibleArgs adds the go build flags which remove the file system paths and build ids
+// from the binary
+func reproducibleArgs(args []string, projectRoot string) ([]string, error) {
+	goVersion, err := retrieveGoVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if goVersionAtLeast(goVersion, 1, 13) {
+		args = append(args, "-trimpath")
+	} else {
+		var pattern string
+		// the package pattern of flags is supported since go1.10
+		if goVersionAtLeast(goVersion, 1, 10) {
+			pattern = "all="
+		}
+		if args, err = mergeFlag(args, "-gcflags", pattern, "-trimpath="+projectRoot); err != nil {
+			return nil, err
+		}
+		if args, err = mergeFlag(args, "-asmflags", pattern, "-trimpath="+projectRoot); err != nil {
+			return nil, err
+		}
+	}
+
+	// the linker only runs for the main package, so the pattern doesn't matter
+	return mergeFlag(args, "-ldflags", "", "-buildid=")
+}
+
+// normalizeDir sets all the files' and directories' modification time under dir to t,
+// and normalizes the permissions to 0755 for directories and executables, 0644 for others.
+func normalizeDir(dir string, t time.Time) error {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		var mode os.FileMode = 0644
+		if info.IsDir() || info.Mode()&0111 != 0 {
+			mode = 0755
+		}
+		if err = os.Chmod(path, mode); err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// change the directories at last since changing files will update the parents' mtime
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err = os.Chtimes(paths[i], t, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashDir returns the sha256 of every file under dir indexed by the relative path
+func hashDir(dir string) (map[string]string, error) {
+	var hashes = make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			hashes[relPath+"/"] = info.Mode().String()
+			return nil
+		}
+
+		h, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		hashes[relPath] = info.Mode().String() + " " + h
+		return nil
+	})
+	return hashes, err
+}
+
+// compareDirs returns the relative paths which are different between the two directories
+func compareDirs(dir1, dir2 string) ([]string, error) {
+	hashes1, err := hashDir(dir1)
+	if err != nil {
+		return nil, err
+	}
+	hashes2, err := hashDir(dir2)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []string
+	for p, h := range hashes1 {
+		if hashes2[p] != h {
+			diffs = append(diffs, p)
+		}
+	}
+	for p := range hashes2 {
+		if _, ok := hashes1[p]; !ok {
+			diffs = append(diffs, p)
+		}
+	}
+	sort.Strings(diffs)
+	return diffs, nil
+}
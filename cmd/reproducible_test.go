@@ -0,0 +1,42 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoVersionAtLeast(t *testing.T) {
+	assert.True(t, goVersionAtLeast("1.13", 1, 13))
+	assert.True(t, goVersionAtLeast("1.13.5", 1, 10))
+	assert.True(t, goVersionAtLeast("1.10beta1", 1, 10))
+	assert.True(t, goVersionAtLeast("devel", 1, 13))
+	assert.False(t, goVersionAtLeast("1.9.7", 1, 10))
+	assert.False(t, goVersionAtLeast("1.12rc1", 1, 13))
+}
+
+func TestMergeFlag(t *testing.T) {
+	args, err := mergeFlag([]string{"-v"}, "-ldflags", "", "-buildid=")
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"-v", "-ldflags", "-buildid="}, args)
+	args, err = mergeFlag([]string{"-ldflags", "-s -w"}, "-ldflags", "", "-buildid=")
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"-ldflags", "-s -w -buildid="}, args)
+	args, err = mergeFlag([]string{"-ldflags=-s"}, "-ldflags", "", "-buildid=")
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"-ldflags=-s -buildid="}, args)
+
+	// the flags of all the packages are merged, the ones of the main packages are rejected
+	args, err = mergeFlag([]string{"-gcflags=all=-N"}, "-gcflags", "all=", "-trimpath=/p")
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"-gcflags=all=-N -trimpath=/p"}, args)
+	args, err = mergeFlag([]string{"-v"}, "-gcflags", "all=", "-trimpath=/p")
+	assert.NoError(t, err)
+	assert.EqualValues(t, []string{"-v", "-gcflags", "all=-trimpath=/p"}, args)
+	_, err = mergeFlag([]string{"-gcflags=-N"}, "-gcflags", "all=", "-trimpath=/p")
+	assert.Error(t, err)
+}
@@ -0,0 +1,178 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// topLevelTest returns the name of the top level test of a subtest, i.e. TestUser of TestUser/name
+func topLevelTest(name string) string {
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// quarantineTests marks the tests of the quarantine list as package.TestName
+func quarantineTests(pkgs []*packageReport, quarantine []string) {
+	var quarantined = make(map[string]bool, len(quarantine))
+	for _, name := range quarantine {
+		quarantined[name] = true
+	}
+	for _, pkg := range pkgs {
+		for _, t := range pkg.Tests {
+			if quarantined[pkg.Package+"."+topLevelTest(t.Name)] {
+				t.Quarantined = true
+			}
+		}
+	}
+}
+
+// failedTests returns the top level tests of the package which failed and are not quarantined
+func failedTests(pkg *packageReport) []string {
+	var names []string
+	var seen = make(map[string]bool)
+	for _, t := range pkg.Tests {
+		name := topLevelTest(t.Name)
+		if t.Status == testFail && !t.Quarantined && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// exactRunRegexp returns the -run pattern which matches the top level tests exactly
+func exactRunRegexp(names []string) string {
+	var quoted = make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, regexp.QuoteMeta(name))
+	}
+	return "^(" + strings.Join(quoted, "|") + ")$"
+}
+
+// retryFailedTests reruns the failed tests of every package at most retries times, the tests
+// passed in a rerun are marked as flaky and the others are still failing
+func retryFailedTests(projectRoot string, args []string, pkgs []*packageReport, retries int, envs []string, jsonOutput bool, stdout io.Writer) {
+	for _, pkg := range pkgs {
+		if pkg.Status != testFail {
+			continue
+		}
+
+		names := failedTests(pkg)
+		for i := 1; i <= retries && len(names) > 0; i++ {
+			fmt.Fprintf(stdout, "=== Retry %d/%d %s: %s\n", i, retries, pkg.Package, strings.Join(names, " "))
+			runArgs := append(append([]string{}, args...), "-run", exactRunRegexp(names))
+			output, _ := runTestPackages(projectRoot, runArgs, []string{pkg.Package}, envs, stdout, nil)
+			_, reruns := parseTestOutput(output, jsonOutput)
+
+			var passed = make(map[string]bool)
+			for _, rerun := range reruns {
+				if rerun.Package != pkg.Package {
+					continue
+				}
+				for _, t := range rerun.Tests {
+					if t.Name == topLevelTest(t.Name) && t.Status == testPass {
+						passed[t.Name] = true
+					}
+				}
+			}
+
+			var failing []string
+			for _, name := range names {
+				if !passed[name] {
+					failing = append(failing, name)
+				}
+			}
+			for _, t := range pkg.Tests {
+				name := topLevelTest(t.Name)
+				if t.Status != testFail || t.Quarantined || !hasArg(names, name) {
+					continue
+				}
+				t.Retries = i
+				if passed[name] {
+					t.Status = testPass
+					t.Flaky = true
+				}
+			}
+			names = failing
+		}
+	}
+}
+
+// testsFailed returns true if there are the tests failing which are not quarantined, or the packages
+// which cannot be built or failed without a failed test
+func testsFailed(pkgs []*packageReport) bool {
+	for _, pkg := range pkgs {
+		if pkg.Status == testError {
+			return true
+		}
+		if pkg.Status != testFail {
+			continue
+		}
+
+		var explained bool
+		for _, t := range pkg.Tests {
+			if t.Status == testFail && !t.Quarantined {
+				return true
+			}
+			if t.Flaky || (t.Status == testFail && t.Quarantined) {
+				explained = true
+			}
+		}
+		if !explained {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreRetriedFailures changes the failed results of the packages whose failures are all flaky or quarantined
+func ignoreRetriedFailures(results []testResult, pkgs []*packageReport) {
+	var ignored = make(map[string]bool)
+	for _, pkg := range pkgs {
+		if pkg.Status == testFail && !testsFailed([]*packageReport{pkg}) {
+			ignored[pkg.Package] = true
+		}
+	}
+	for i, result := range results {
+		if result.Status == "FAIL" && ignored[result.Package] {
+			results[i].Status = "ok"
+			results[i].Detail = strings.TrimSpace(result.Detail + " (flaky or quarantined)")
+		}
+	}
+}
+
+// printRetriedTests prints the flaky, the failing and the quarantined failed tests
+func printRetriedTests(w io.Writer, pkgs []*packageReport) {
+	var lines []string
+	for _, pkg := range pkgs {
+		for _, t := range pkg.Tests {
+			name := pkg.Package + "." + t.Name
+			switch {
+			case t.Status == testFail && t.Quarantined:
+				lines = append(lines, fmt.Sprintf("QUARANTINED %s", name))
+			case t.Status == testFail && t.Retries > 0:
+				lines = append(lines, fmt.Sprintf("FAIL        %s (failed %d retries)", name, t.Retries))
+			case t.Status == testFail:
+				lines = append(lines, fmt.Sprintf("FAIL        %s", name))
+			case t.Flaky:
+				lines = append(lines, fmt.Sprintf("FLAKY       %s (passed after %d retries)", name, t.Retries))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "\n=== Failed tests")
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
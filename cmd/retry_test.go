@@ -0,0 +1,49 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetriedTests(t *testing.T) {
+	assert.EqualValues(t, `^(TestUser|Test\.Dot)$`, exactRunRegexp([]string{"TestUser", "Test.Dot"}))
+
+	pkgs := []*packageReport{
+		{Package: "models", Status: testFail, Tests: []*testCaseReport{
+			{Name: "TestUser", Status: testFail},
+			{Name: "TestUser/name", Status: testFail},
+			{Name: "TestFlaky", Status: testFail},
+			{Name: "TestPass", Status: testPass},
+		}},
+		{Package: "routes", Status: testFail, Tests: []*testCaseReport{
+			{Name: "TestAPI", Status: testFail},
+		}},
+	}
+	quarantineTests(pkgs, []string{"models.TestFlaky"})
+	assert.EqualValues(t, []string{"TestUser"}, failedTests(pkgs[0]))
+	assert.True(t, testsFailed(pkgs))
+
+	// the failed tests passed in the reruns
+	pkgs[0].Tests[0].Status, pkgs[0].Tests[0].Flaky = testPass, true
+	pkgs[0].Tests[1].Status, pkgs[0].Tests[1].Flaky = testPass, true
+	assert.False(t, testsFailed(pkgs[:1]))
+	assert.True(t, testsFailed(pkgs))
+
+	results := []testResult{
+		{Package: "models", Status: "FAIL", Detail: "0.010s"},
+		{Package: "routes", Status: "FAIL", Detail: "0.020s"},
+	}
+	ignoreRetriedFailures(results, pkgs)
+	assert.EqualValues(t, []testResult{
+		{Package: "models", Status: "ok", Detail: "0.010s (flaky or quarantined)"},
+		{Package: "routes", Status: "FAIL", Detail: "0.020s"},
+	}, results)
+
+	// a package failed without failed tests, i.e. TestMain exits with 1
+	assert.True(t, testsFailed([]*packageReport{{Package: "main", Status: testFail}}))
+}
@@ -5,17 +5,19 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/urfave/cli"
-	fsnotify "gopkg.in/fsnotify.v1"
 )
 
 // CmdRun represents
@@ -27,63 +29,152 @@ var CmdRun = cli.Command{
 	SkipFlagParsing: true,
 }
 
-var process *os.Process
-var processLock sync.Mutex
+// defaultGracePeriod is the time to wait the old process exiting before killing it
+const defaultGracePeriod = 5 * time.Second
+
+// runSpec describes how to start the binary of a target
+type runSpec struct {
+	Path string
+	Args []string
+	Env  []string
+	Dir  string
+	// Stdout and Stderr are os.Stdout and os.Stderr if they are nil
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// newRunSpec creates the run spec of the target according the run section of gop.yml,
+// programArgs are appended to the configured args. The environment variables are the inherited ones
+// overridden by the env file and then the env of the run section.
+func newRunSpec(projectRoot string, target *Target, exePath string, programArgs []string) (*runSpec, error) {
+	targetDir := filepath.Join(projectRoot, "src", target.Dir)
+	spec := &runSpec{
+		Path: exePath,
+		Args: append(append([]string{exePath}, target.Run.Args...), programArgs...),
+		Env:  os.Environ(),
+		Dir:  filepath.Dir(exePath),
+	}
+
+	envFile := target.Run.EnvFile
+	if envFile == "" {
+		// the .env file of the target directory or the project root will be loaded if it exists
+		for _, dir := range []string{targetDir, projectRoot} {
+			if exist, _ := isFileExist(filepath.Join(dir, ".env")); exist {
+				envFile = filepath.Join(dir, ".env")
+				break
+			}
+		}
+	} else if !filepath.IsAbs(envFile) {
+		envFile = filepath.Join(targetDir, envFile)
+	}
+	if envFile != "" {
+		envs, err := loadDotEnv(envFile)
+		if err != nil {
+			return nil, err
+		}
+		Println("Loaded env file", envFile)
+		spec.Env = mergeEnv(spec.Env, envs)
+	}
+	spec.Env = mergeEnv(spec.Env, target.Run.Env)
+
+	if target.Run.WorkDir != "" {
+		spec.Dir = target.Run.WorkDir
+		if !filepath.IsAbs(spec.Dir) {
+			spec.Dir = filepath.Join(targetDir, spec.Dir)
+		}
+	}
+	return spec, nil
+}
+
+// runBinary starts the binary, if wait is false it will be started in the background in a new process group.
+// The output is copied through pipes if the writers of the spec are not files.
+func runBinary(spec *runSpec, wait bool) (*os.Process, error) {
+	var files = []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	var pipes []*os.File
+	for i, w := range []io.Writer{spec.Stdout, spec.Stderr} {
+		if w == nil {
+			continue
+		}
+		if f, ok := w.(*os.File); ok {
+			files[i+1] = f
+			continue
+		}
+
+		r, pw, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		files[i+1] = pw
+		pipes = append(pipes, pw)
+		go func(w io.Writer, r *os.File) {
+			io.Copy(w, r)
+			r.Close()
+		}(w, r)
+	}
 
-func runBinary(exePath string, wait bool) error {
 	attr := &os.ProcAttr{
-		Dir:   filepath.Dir(exePath),
-		Env:   os.Environ(),
-		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+		Dir:   spec.Dir,
+		Env:   spec.Env,
+		Files: files,
+	}
+	// the background process is started in a new process group so that it could be stopped with its children
+	if !wait {
+		attr.Sys = newProcessGroupAttr()
 	}
 
-	var err error
-	process, err = os.StartProcess(filepath.Base(exePath), []string{exePath}, attr)
+	p, err := os.StartProcess(spec.Path, spec.Args, attr)
+	// the write ends belong to the child now
+	for _, pw := range pipes {
+		pw.Close()
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if wait {
-		_, err = process.Wait()
+		_, err = p.Wait()
 	}
-	return err
+	return p, err
 }
 
-func killOldProcess(done chan bool) {
-	fmt.Println("=== Killing the old process")
-	if process != nil {
-		if err := process.Kill(); err != nil {
-			log.Println("Killing old process error:", err)
-			done <- false
-			processLock.Unlock()
+// waitPortReleased waits until the port could be listened or timeout
+func waitPortReleased(port int, timeout time.Duration) {
+	if port <= 0 {
+		return
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err == nil {
+			l.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			fmt.Printf("=== Port %d is still in use after %s\n", port, timeout)
 			return
 		}
-		process = nil
+		time.Sleep(100 * time.Millisecond)
 	}
 }
 
-func reBuildAndRun(ctx *cli.Context, args cli.Args, isWindows, ensureFlag bool, exePath string, done chan bool) {
-	processLock.Lock()
-	if isWindows {
-		killOldProcess(done)
+// gracePeriod returns the grace period of stopping the target
+func gracePeriod(target *Target) time.Duration {
+	if target.Run.GracePeriod > 0 {
+		return target.Run.GracePeriod
 	}
+	return defaultGracePeriod
+}
 
-	fmt.Printf("=== Rebuilding %s ...\n", args)
-	err := runBuildNoCtx(ctx, args, isWindows, ensureFlag)
-	if err != nil {
-		log.Println("Build error:", err)
-	} else {
-		if !isWindows {
-			killOldProcess(done)
-		}
-
-		fmt.Printf("=== Running %s ...\n", exePath)
-		err = runBinary(exePath, false)
-		if err != nil {
-			log.Println("Run binary error:", err)
-		}
+// stopTargetProcess stops the process gracefully according the target's configuration,
+// exited is closed when the wait of the process returns
+func stopTargetProcess(p *os.Process, exited <-chan struct{}, target *Target) error {
+	grace := gracePeriod(target)
+	if err := stopProcess(p, exited, target.Run.StopSignal, grace); err != nil {
+		return err
 	}
-	processLock.Unlock()
+	waitPortReleased(target.Run.Port, grace)
+	return nil
 }
 
 const (
@@ -92,185 +183,136 @@ const (
 	needReRun
 )
 
-func needReBuild(projectRoot, fileName string) int {
-	if strings.HasSuffix(fileName, ".go") {
-		return needReBuildAndRun
-	} else if strings.HasSuffix(fileName, ".log") {
-		return noNeedReBuildAndRun
-	}
-
-	for _, f := range curTarget.Monitors {
-		if filepath.Join(projectRoot, "src", curTarget.Dir, f) == fileName {
-			return needReRun
-		}
-	}
-	return noNeedReBuildAndRun
+// notifyDone sends true to done when gop is interrupted, the processes in the background
+// process groups will not receive the signal from the terminal so they should be stopped by gop
+func notifyDone(done chan bool) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		done <- true
+	}()
 }
 
 func runRun(ctx *cli.Context) error {
 	var (
-		watchFlagIdx  = -1
-		ensureFlagIdx = -1
-		args          = ctx.Args()
+		watchFlag   bool
+		watchDebug  bool
+		ensureFlag  bool
+		forceFlag   bool
+		allFlag     bool
+		group       string
+		proxyAddr   string
+		cmdArgs     = ctx.Args()
+		args        = make([]string, 0, len(cmdArgs))
+		programArgs []string
 	)
-	for i, arg := range args {
-		if arg == "-v" {
-			showLog = true
-		} else if arg == "-w" {
-			watchFlagIdx = i
-		} else if arg == "-e" {
-			ensureFlagIdx = i
+	for i := 0; i < len(cmdArgs); i++ {
+		arg := cmdArgs[i]
+		// the arguments after -- are passed to the program
+		if arg == "--" {
+			programArgs = cmdArgs[i+1:]
+			break
 		}
-	}
 
-	if watchFlagIdx > -1 {
-		args = append(args[:watchFlagIdx], args[watchFlagIdx+1:]...)
-		if ensureFlagIdx > watchFlagIdx {
-			ensureFlagIdx = ensureFlagIdx - 1
+		switch {
+		case arg == "-v":
+			showLog = true
+			args = append(args, arg)
+		case arg == "-w":
+			watchFlag = true
+		case arg == "--watch-debug":
+			watchDebug = true
+		case arg == "-e":
+			ensureFlag = true
+		case arg == "--force":
+			forceFlag = true
+		case arg == "--all":
+			allFlag = true
+		case arg == "--group":
+			if i == len(cmdArgs)-1 {
+				return errors.New("--group needs a group name")
+			}
+			i++
+			group = cmdArgs[i]
+		case strings.HasPrefix(arg, "--group="):
+			group = strings.TrimPrefix(arg, "--group=")
+		case arg == "--proxy":
+			if i == len(cmdArgs)-1 {
+				return errors.New("--proxy needs an address to listen on")
+			}
+			i++
+			proxyAddr = cmdArgs[i]
+		case strings.HasPrefix(arg, "--proxy="):
+			proxyAddr = strings.TrimPrefix(arg, "--proxy=")
+		default:
+			args = append(args, arg)
 		}
 	}
-	if ensureFlagIdx > -1 {
-		args = append(args[:ensureFlagIdx], args[ensureFlagIdx+1:]...)
+
+	if proxyAddr != "" && (!watchFlag || allFlag || group != "") {
+		return errors.New("--proxy can only be used with -w for one target")
 	}
 
 	var isWindows = runtime.GOOS == "windows"
+	if allFlag || group != "" {
+		return runGroup(ctx, group, args, programArgs, isWindows, watchFlag, watchDebug, ensureFlag, forceFlag)
+	}
+
 	// gop run don't support cross compile
-	err := runBuildNoCtx(ctx, args, isWindows, ensureFlagIdx > -1)
+	target, _, err := runBuildNoCtx(ctx, args, isWindows, ensureFlag, forceFlag)
 	if err != nil {
 		return err
 	}
 
-	_, projectRoot, err := analysisDirLevel()
+	level, projectRoot, err := analysisDirLevel()
 	if err != nil {
 		return err
 	}
 
-	var ext string
-	if isWindows {
-		ext = ".exe"
+	var targetName string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		targetName = args[0]
+		args = args[1:]
 	}
 
-	exePath := filepath.Join(projectRoot, "src", curTarget.Dir, curTarget.Name+ext)
-	exePath, _ = filepath.Abs(exePath)
-
-	if watchFlagIdx <= -1 {
-		return runBinary(exePath, true)
+	runner := &targetRunner{
+		ctx:         ctx,
+		projectRoot: projectRoot,
+		level:       level,
+		targetName:  targetName,
+		args:        args,
+		programArgs: programArgs,
+		isWindows:   isWindows,
+		stdout:      os.Stdout,
+		stderr:      os.Stderr,
+		target:      target,
 	}
-
-	go func() {
-		processLock.Lock()
-		err := runBinary(exePath, false)
-		if err != nil {
-			Println("Run failed:", err)
-			process = nil
-		}
-		processLock.Unlock()
-	}()
-
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
+	if err = runner.prepare(); err != nil {
 		return err
 	}
-	defer watcher.Close()
 
-	err = filepath.Walk(filepath.Join(projectRoot, "src"), func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			watcher.Add(path)
-		}
-		return nil
-	})
-	if err != nil {
+	if !watchFlag {
+		_, err = runBinary(runner.spec, true)
 		return err
 	}
 
-	done := make(chan bool)
-	var lastTimeLock sync.Mutex
-	var lastTime time.Time
-	var needChangeType int
-
-	go func() {
-		for {
-			select {
-			case event := <-watcher.Events:
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					needChange := needReBuild(projectRoot, event.Name)
-					if needChange == needReBuildAndRun || needChange == needReRun {
-						exist, _ := isFileExist(event.Name)
-						if exist {
-							lastTimeLock.Lock()
-							lastTime = time.Now()
-							needChangeType = needChange
-							lastTimeLock.Unlock()
-						}
-					}
-				} else if event.Op&fsnotify.Rename == fsnotify.Rename {
-					needChange := needReBuild(projectRoot, event.Name)
-					if needChange == needReBuildAndRun || needChange == needReRun {
-						lastTimeLock.Lock()
-						lastTime = time.Now()
-						needChangeType = needChange
-						lastTimeLock.Unlock()
-					}
-				} else if event.Op&fsnotify.Create == fsnotify.Create {
-					exist, _ := isDirExist(event.Name)
-					if exist {
-						watcher.Add(event.Name)
-					}
-				} else if event.Op&fsnotify.Remove == fsnotify.Remove {
-					watcher.Remove(event.Name)
-					needChange := needReBuild(projectRoot, event.Name)
-					if needChange == needReBuildAndRun || needChange == needReRun {
-						lastTimeLock.Lock()
-						lastTime = time.Now()
-						needChangeType = needChange
-						lastTimeLock.Unlock()
-					}
-				}
-			case err := <-watcher.Errors:
-				log.Println("error:", err)
-				done <- false
-				return
-			case <-time.After(200 * time.Millisecond):
-				var reBuild bool
-				var reType int
-				now := time.Now()
-				lastTimeLock.Lock()
-				reBuild = !lastTime.IsZero() && now.Unix()-lastTime.Unix() >= 1
-				if reBuild {
-					lastTime = time.Time{}
-					reType = needChangeType
-				}
-				lastTimeLock.Unlock()
-				if reBuild {
-					switch reType {
-					case needReBuildAndRun:
-						reBuildAndRun(ctx, args, isWindows, ensureFlagIdx > -1, exePath, done)
-					case needReRun:
-						processLock.Lock()
-						killOldProcess(done)
-
-						fmt.Printf("=== Running %s ...\n", exePath)
-						err = runBinary(exePath, false)
-						if err != nil {
-							log.Println("Run binary error:", err)
-						}
-						processLock.Unlock()
-					}
-				}
-			}
+	if proxyAddr != "" {
+		if target.Run.Port <= 0 {
+			return errors.New("--proxy needs the port of the target in the run section of gop.yml")
 		}
-	}()
-
-	<-done
-
-	if process != nil {
-		if err := process.Kill(); err != nil {
-			log.Println("error:", err)
+		runner.proxy = newLiveReloadProxy(target.Run.Port, target.Run.HealthCheck)
+		if err = runner.proxy.listen(proxyAddr); err != nil {
+			return err
 		}
 	}
 
-	return nil
+	runner.lock.Lock()
+	runner.start()
+	runner.lock.Unlock()
+	if runner.proxy != nil {
+		// the changes are watched while waiting the health check of the first process
+		go runner.proxy.release(true)
+	}
+	return watchTargets(projectRoot, []*targetRunner{runner}, ensureFlag, watchDebug)
 }
@@ -0,0 +1,515 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// targetRunner builds, runs and restarts one target of gop run
+type targetRunner struct {
+	ctx         *cli.Context
+	projectRoot string
+	level       int
+	targetName  string
+	args        []string
+	programArgs []string
+	isWindows   bool
+	stdout      io.Writer
+	stderr      io.Writer
+	// exits receives the runner when its process exits by itself
+	exits chan *targetRunner
+	// proxy holds the requests while restarting and reloads the browsers after restarted
+	proxy *liveReloadProxy
+
+	// lock serializes building, starting and stopping
+	lock    sync.Mutex
+	target  *Target
+	exePath string
+	spec    *runSpec
+	rules   *watchRules
+	pkgDirs map[string]bool
+
+	// processLock guards the process and the state of the supervision
+	processLock sync.Mutex
+	process     *os.Process
+	// exited is closed when the process is waited
+	exited     chan struct{}
+	generation int
+	restarts   int
+	closed     bool
+}
+
+// prepare creates the run spec and the watch rules of the target
+func (r *targetRunner) prepare() error {
+	var ext string
+	if r.isWindows {
+		ext = ".exe"
+	}
+
+	exePath := filepath.Join(r.projectRoot, "src", r.target.Dir, r.target.Name+ext)
+	exePath, _ = filepath.Abs(exePath)
+
+	spec, err := newRunSpec(r.projectRoot, r.target, exePath, r.programArgs)
+	if err != nil {
+		return err
+	}
+	spec.Stdout, spec.Stderr = r.stdout, r.stderr
+
+	r.exePath, r.spec = exePath, spec
+	r.rules = newWatchRules(r.projectRoot, r.target, exePath)
+	r.updatePkgDirs()
+	return nil
+}
+
+// updatePkgDirs records the package directories the target depends on, if they cannot be listed,
+// i.e. the source has syntax errors, all the source changes will rebuild the target
+func (r *targetRunner) updatePkgDirs() {
+	dirs, err := targetPackageDirs(r.projectRoot, r.target, r.args, projectEnv(r.projectRoot))
+	if err != nil {
+		Fprintln(r.stdout, "List dependencies failed:", err)
+		r.pkgDirs = nil
+		return
+	}
+
+	r.pkgDirs = make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		r.pkgDirs[dir] = true
+	}
+}
+
+// reload finds the target again after gop.yml reloaded
+func (r *targetRunner) reload() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	target, err := analysisTarget(r.level, r.targetName, r.projectRoot)
+	if err != nil {
+		return err
+	}
+	// the name may be changed by -o
+	target.Name = r.target.Name
+
+	old := r.target
+	r.target = target
+	if err = r.prepare(); err != nil {
+		r.target = old
+		return err
+	}
+	return nil
+}
+
+// action returns what should be done for the target when the files changed,
+// the source files of the packages which the target doesn't depend on are skipped
+func (r *targetRunner) action(files []string) int {
+	var action = noNeedReBuildAndRun
+	for _, f := range files {
+		a, _ := r.rules.match(f)
+		if a == needReBuildAndRun && r.pkgDirs != nil && sourceExts[filepath.Ext(f)] && !r.pkgDirs[filepath.Dir(f)] {
+			continue
+		}
+		if a == needReBuildAndRun {
+			return a
+		}
+		if a == needReRun {
+			action = a
+		}
+	}
+	return action
+}
+
+func (r *targetRunner) build(ensureFlag, force bool) (bool, error) {
+	built, err := buildTarget(r.ctx, r.projectRoot, r.target, r.args, r.isWindows, ensureFlag, force, "", r.stdout, r.stderr)
+	if err == nil {
+		r.updatePkgDirs()
+	}
+	return built, err
+}
+
+// newBinaryPath returns the temporary path the binary is rebuilt to
+func newBinaryPath(exePath string) string {
+	return filepath.Join(filepath.Dir(exePath), "."+filepath.Base(exePath)+".new")
+}
+
+// rebuild builds the target to the temporary path, so the running binary is kept if the build fails.
+// The compiler errors are summarized and written to the errors file of the target.
+func (r *targetRunner) rebuild(ensureFlag bool) (bool, error) {
+	var output bytes.Buffer
+	tmpPath := newBinaryPath(r.exePath)
+	built, err := buildTarget(r.ctx, r.projectRoot, r.target, r.args, r.isWindows, ensureFlag, false, tmpPath, r.stdout, &output)
+
+	errs := parseBuildErrors(output.Bytes(), filepath.Join(r.projectRoot, "src", r.target.Dir))
+	if werr := writeBuildErrors(buildErrorsPath(r.projectRoot, r.target), errs); werr != nil {
+		fmt.Fprintln(r.stderr, "Write build errors failed:", werr)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		printBuildErrors(r.stderr, r.projectRoot, errs, output.Bytes())
+		return false, err
+	}
+	r.stderr.Write(output.Bytes())
+	r.updatePkgDirs()
+	return built, nil
+}
+
+// swapBinary replaces the binary and its fingerprint with the rebuilt ones
+func (r *targetRunner) swapBinary() error {
+	tmpPath := newBinaryPath(r.exePath)
+	if err := os.Rename(tmpPath, r.exePath); err != nil {
+		return err
+	}
+	if err := os.Rename(fingerprintPath(tmpPath), fingerprintPath(r.exePath)); err != nil {
+		// the old fingerprint doesn't match the new binary
+		os.Remove(fingerprintPath(r.exePath))
+	}
+	return nil
+}
+
+func (r *targetRunner) isRunning() bool {
+	r.processLock.Lock()
+	defer r.processLock.Unlock()
+	return r.process != nil
+}
+
+// start starts the binary in the background, the lock should be held
+func (r *targetRunner) start() {
+	target := r.target
+	fmt.Fprintf(r.stdout, "=== Running %s ...\n", r.spec.Path)
+	p, err := runBinary(r.spec, false)
+	if err != nil {
+		fmt.Fprintln(r.stderr, "Run binary error:", err)
+		if !r.scheduleRestart(target, false) && r.exits != nil {
+			r.exits <- r
+		}
+		return
+	}
+
+	exited := make(chan struct{})
+	r.processLock.Lock()
+	r.process = p
+	r.exited = exited
+	r.generation++
+	gen := r.generation
+	r.processLock.Unlock()
+
+	startTime := time.Now()
+	go r.waitReady(target, p, gen)
+	go func() {
+		// the process is only waited here, stop is told by exited
+		state, err := p.Wait()
+		close(exited)
+
+		r.processLock.Lock()
+		current := r.process == p
+		if current {
+			r.process, r.exited = nil, nil
+		}
+		r.processLock.Unlock()
+
+		// the process is stopped by gop
+		if !current {
+			return
+		}
+		var success bool
+		if err != nil {
+			fmt.Fprintln(r.stderr, "Wait process error:", err)
+		} else {
+			success = state.Success()
+			fmt.Fprintf(r.stdout, "=== %s exited with %s after running %s\n", target.Name, state, roundDuration(time.Since(startTime)))
+		}
+		if !r.scheduleRestart(target, success) && r.exits != nil {
+			r.exits <- r
+		}
+	}()
+}
+
+// stop stops the running process gracefully, the lock should be held
+func (r *targetRunner) stop() error {
+	r.processLock.Lock()
+	p, exited := r.process, r.exited
+	r.process, r.exited = nil, nil
+	r.processLock.Unlock()
+
+	if p == nil {
+		return nil
+	}
+	fmt.Fprintln(r.stdout, "=== Stopping the old process")
+	return stopTargetProcess(p, exited, r.target)
+}
+
+// restart rebuilds the target if rebuild is true and restarts it. If nothing changed after
+// rebuilding the running process will be kept, an error is returned only if the old process cannot be stopped.
+func (r *targetRunner) restart(rebuild, ensureFlag bool) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// a change restarts the process, so the restarts caused by crashes are counted again
+	r.processLock.Lock()
+	r.restarts = 0
+	r.processLock.Unlock()
+
+	var restarted bool
+	if r.proxy != nil {
+		r.proxy.hold()
+		defer func() {
+			r.proxy.release(restarted)
+		}()
+	}
+
+	var built bool
+	if rebuild {
+		fmt.Fprintf(r.stdout, "=== Rebuilding %s ...\n", r.target.Name)
+		var err error
+		if built, err = r.rebuild(ensureFlag); err != nil {
+			fmt.Fprintln(r.stderr, "Build error:", err)
+			if r.isRunning() {
+				fmt.Fprintf(r.stderr, "=== %s is stale, the last good process keeps running\n", r.target.Name)
+			}
+			return nil
+		}
+		if !built && r.isRunning() {
+			fmt.Fprintln(r.stdout, "=== Nothing changed, keep the old process running")
+			return nil
+		}
+	}
+
+	if err := r.stop(); err != nil {
+		return err
+	}
+	// the binary is replaced after the old process stopped since a running binary cannot be replaced on windows
+	if built {
+		if err := r.swapBinary(); err != nil {
+			fmt.Fprintln(r.stderr, "Replace binary error:", err)
+			return nil
+		}
+	}
+	r.start()
+	restarted = r.isRunning()
+	return nil
+}
+
+// stopRunners stops all the runners concurrently, so the grace periods don't add up
+func stopRunners(runners []*targetRunner) {
+	var wg sync.WaitGroup
+	for _, r := range runners {
+		wg.Add(1)
+		go func(r *targetRunner) {
+			defer wg.Done()
+			r.processLock.Lock()
+			r.closed = true
+			r.processLock.Unlock()
+
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			if err := r.stop(); err != nil {
+				log.Println("error:", err)
+			}
+		}(r)
+	}
+	wg.Wait()
+}
+
+func runnersRules(runners []*targetRunner) []*watchRules {
+	var rules = make([]*watchRules, 0, len(runners))
+	for _, r := range runners {
+		rules = append(rules, r.rules)
+	}
+	return rules
+}
+
+// watchTargets watches the project and rebuilds or restarts the runners whose files changed
+// until gop is interrupted
+func watchTargets(projectRoot string, runners []*targetRunner, ensureFlag, debug bool) error {
+	var delay time.Duration
+	for _, r := range runners {
+		if r.target.Watch.Delay > delay {
+			delay = r.target.Watch.Delay
+		}
+	}
+
+	watcher, err := newProjectWatcher(projectRoot, runnersRules(runners), delay, debug)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case change := <-watcher.Changes:
+				if change.Reload {
+					fmt.Println("=== Reloading gop.yml")
+					if err := loadConfig(filepath.Join(projectRoot, "gop.yml")); err != nil {
+						log.Println("Reload gop.yml error:", err)
+						continue
+					}
+					for _, r := range runners {
+						if err := r.reload(); err != nil {
+							log.Println("Reload gop.yml error:", err)
+						}
+					}
+					watcher.SetRules(runnersRules(runners))
+				}
+
+				for _, r := range runners {
+					action := r.action(change.Files)
+					if change.Ensure {
+						action = needReBuildAndRun
+					}
+					if action == noNeedReBuildAndRun {
+						continue
+					}
+
+					if err := r.restart(action == needReBuildAndRun, ensureFlag || change.Ensure); err != nil {
+						log.Println("Stopping old process error:", err)
+						done <- false
+						return
+					}
+				}
+			case err := <-watcher.Errors:
+				log.Println("error:", err)
+				done <- false
+				return
+			}
+		}
+	}()
+
+	notifyDone(done)
+	<-done
+
+	stopRunners(runners)
+	return nil
+}
+
+// superviseTargets waits until all the processes exited or gop is interrupted
+func superviseTargets(runners []*targetRunner, exits chan *targetRunner) error {
+	// every runner sends to exits once its process exits and will not be restarted
+	var running = len(runners)
+	done := make(chan bool)
+	notifyDone(done)
+	for running > 0 {
+		select {
+		case <-exits:
+			running--
+		case <-done:
+			stopRunners(runners)
+			return nil
+		}
+	}
+	return nil
+}
+
+// runGroup builds and runs all the targets of the project or the group defined in gop.yml,
+// the output lines of every target are prefixed with its name
+func runGroup(ctx *cli.Context, group string, args, programArgs []string, isWindows, watchFlag, watchDebug, ensureFlag, forceFlag bool) error {
+	_, projectRoot, err := analysisDirLevel()
+	if err != nil {
+		return err
+	}
+
+	if err = loadConfig(filepath.Join(projectRoot, "gop.yml")); err != nil {
+		return err
+	}
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return fmt.Errorf("target %s cannot be run with --all or --group", args[0])
+	}
+
+	var names []string
+	if group != "" {
+		var ok bool
+		if names, ok = config.Groups[group]; !ok {
+			return fmt.Errorf("group %s is not defined in gop.yml", group)
+		}
+	} else {
+		for _, t := range config.Targets {
+			names = append(names, t.Name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("group %s has no targets", group)
+	}
+
+	var (
+		outLock = new(sync.Mutex)
+		width   int
+		exits   chan *targetRunner
+		runners = make([]*targetRunner, 0, len(names))
+	)
+	if !watchFlag {
+		exits = make(chan *targetRunner, len(names))
+	}
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	for i, name := range names {
+		target, err := analysisTarget(dirLevelRoot, name, projectRoot)
+		if err != nil {
+			return err
+		}
+
+		prefix := colorize(fmt.Sprintf("%-*s", width+2, "["+name+"]"), i)
+		runners = append(runners, &targetRunner{
+			ctx:         ctx,
+			projectRoot: projectRoot,
+			level:       dirLevelRoot,
+			targetName:  name,
+			args:        args,
+			programArgs: programArgs,
+			isWindows:   isWindows,
+			stdout:      newPrefixWriter(os.Stdout, outLock, prefix),
+			stderr:      newPrefixWriter(os.Stderr, outLock, prefix),
+			exits:       exits,
+			target:      target,
+		})
+	}
+
+	// the targets are built one by one since ensure may download the same dependencies
+	var failed int
+	var builtOK = make([]bool, len(runners))
+	for i, r := range runners {
+		if _, err = r.build(ensureFlag, forceFlag); err != nil {
+			fmt.Fprintln(r.stderr, "Build error:", err)
+			failed++
+		} else {
+			builtOK[i] = true
+		}
+		if err = r.prepare(); err != nil {
+			return err
+		}
+	}
+	if failed > 0 && !watchFlag {
+		return fmt.Errorf("%d of %d targets failed to build", failed, len(runners))
+	}
+
+	// in watch mode the targets failed to build will be started after they are fixed
+	for i, r := range runners {
+		if builtOK[i] {
+			r.lock.Lock()
+			r.start()
+			r.lock.Unlock()
+		}
+	}
+
+	if watchFlag {
+		return watchTargets(projectRoot, runners, ensureFlag, watchDebug)
+	}
+	return superviseTargets(runners, exits)
+}
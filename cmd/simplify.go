@@ -0,0 +1,100 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// simplifier applies the simplifications of gofmt -s
+type simplifier struct{}
+
+// Visit simplifies the composite literals, the slice expressions and the range clauses
+func (s simplifier) Visit(node ast.Node) ast.Visitor {
+	switch n := node.(type) {
+	case *ast.CompositeLit:
+		// [][]int{[]int{1}} -> [][]int{{1}}, []*T{&T{}} -> []*T{{}}
+		var keyType, eltType ast.Expr
+		switch typ := n.Type.(type) {
+		case *ast.ArrayType:
+			eltType = typ.Elt
+		case *ast.MapType:
+			keyType = typ.Key
+			eltType = typ.Value
+		}
+
+		if eltType != nil {
+			for i, x := range n.Elts {
+				if kv, ok := x.(*ast.KeyValueExpr); ok {
+					kv.Key = simplifyLit(keyType, kv.Key)
+					kv.Value = simplifyLit(eltType, kv.Value)
+				} else {
+					n.Elts[i] = simplifyLit(eltType, x)
+				}
+			}
+		}
+	case *ast.SliceExpr:
+		// s[a:len(s)] -> s[a:]
+		if n.Max != nil || n.High == nil {
+			break
+		}
+		s, ok := n.X.(*ast.Ident)
+		if !ok || s.Obj == nil {
+			break
+		}
+		call, ok := n.High.(*ast.CallExpr)
+		if !ok || len(call.Args) != 1 || call.Ellipsis.IsValid() {
+			break
+		}
+		fun, ok := call.Fun.(*ast.Ident)
+		if !ok || fun.Name != "len" || fun.Obj != nil {
+			break
+		}
+		if arg, ok := call.Args[0].(*ast.Ident); ok && arg.Obj == s.Obj {
+			n.High = nil
+		}
+	case *ast.RangeStmt:
+		// for x, _ = range v -> for x = range v, for _ = range v -> for range v
+		if isBlank(n.Value) {
+			n.Value = nil
+		}
+		if isBlank(n.Key) && n.Value == nil {
+			n.Key = nil
+			n.Tok = token.ILLEGAL
+		}
+	}
+	return s
+}
+
+func isBlank(x ast.Expr) bool {
+	ident, ok := x.(*ast.Ident)
+	return ok && ident.Name == "_"
+}
+
+// simplifyLit removes the type of the composite literal x if it's the same as typ
+func simplifyLit(typ, x ast.Expr) ast.Expr {
+	if typ == nil {
+		return x
+	}
+
+	if lit, ok := x.(*ast.CompositeLit); ok && lit.Type != nil && types.ExprString(lit.Type) == types.ExprString(typ) {
+		lit.Type = nil
+		return lit
+	}
+
+	ptr, ok := typ.(*ast.StarExpr)
+	if !ok {
+		return x
+	}
+	if addr, ok := x.(*ast.UnaryExpr); ok && addr.Op == token.AND {
+		if lit, ok := addr.X.(*ast.CompositeLit); ok && lit.Type != nil && types.ExprString(lit.Type) == types.ExprString(ptr.X) {
+			lit.Type = nil
+			return lit
+		}
+	}
+	return x
+}
@@ -52,13 +52,14 @@ func runStatus(ctx *cli.Context) error {
 		targetName = args[0]
 	}
 
-	if err = analysisTarget(level, targetName, projectRoot); err != nil {
+	target, err := analysisTarget(level, targetName, projectRoot)
+	if err != nil {
 		return err
 	}
 
 	vendorDir := filepath.Join(projectRoot, "src", "vendor")
 
-	imports, err := ListImports(projectRoot, curTarget.Dir, projectRoot,
+	imports, err := ListImports(projectRoot, target.Dir, projectRoot,
 		filepath.Join(projectRoot, "src"), ctx.String("tags"), ctx.Bool("test"))
 	if err != nil {
 		return err
@@ -0,0 +1,193 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// the restart policies of the run section
+const (
+	restartNever     = "never"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+const (
+	defaultRestartBackoff = time.Second
+	maxRestartBackoff     = time.Minute
+	defaultReadyTimeout   = 30 * time.Second
+	// stableUptime is the time a process without ready check should run to be considered ready
+	stableUptime = 10 * time.Second
+)
+
+// restartBackoff returns the delay before the n-th restart, it's doubled every time
+func restartBackoff(initial time.Duration, n int) time.Duration {
+	if initial <= 0 {
+		initial = defaultRestartBackoff
+	}
+	d := initial
+	for i := 0; i < n; i++ {
+		d *= 2
+		if d >= maxRestartBackoff {
+			return maxRestartBackoff
+		}
+	}
+	return d
+}
+
+// roundDuration rounds d to 0.1s for reporting
+func roundDuration(d time.Duration) time.Duration {
+	return d - d%(100*time.Millisecond)
+}
+
+// isReady checks the http:// or https:// URL, or the tcp://host:port address once
+func isReady(check string) (bool, error) {
+	u, err := url.Parse(check)
+	if err != nil {
+		return false, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		client := &http.Client{Timeout: time.Second}
+		resp, err := client.Get(check)
+		if err != nil {
+			return false, nil
+		}
+		resp.Body.Close()
+		return resp.StatusCode < http.StatusBadRequest, nil
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+		if err != nil {
+			return false, nil
+		}
+		conn.Close()
+		return true, nil
+	}
+	return false, fmt.Errorf("unsupported ready check %s, it should be http://, https:// or tcp://", check)
+}
+
+// isCurrent returns true if p is still the process of the generation
+func (r *targetRunner) isCurrent(p *os.Process, gen int) bool {
+	r.processLock.Lock()
+	defer r.processLock.Unlock()
+	return r.process == p && r.generation == gen
+}
+
+// markReady resets the restart count once the process started successfully
+func (r *targetRunner) markReady(p *os.Process, gen int) bool {
+	r.processLock.Lock()
+	defer r.processLock.Unlock()
+	if r.process != p || r.generation != gen {
+		return false
+	}
+	r.restarts = 0
+	return true
+}
+
+// waitReady waits the ready check of the target passing. Without a ready check,
+// the process is ready after running for stableUptime.
+func (r *targetRunner) waitReady(target *Target, p *os.Process, gen int) {
+	check := target.Run.ReadyCheck
+	if check == "" {
+		time.AfterFunc(stableUptime, func() {
+			r.markReady(p, gen)
+		})
+		return
+	}
+
+	timeout := target.Run.ReadyTimeout
+	if timeout <= 0 {
+		timeout = defaultReadyTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) && r.isCurrent(p, gen) {
+		ready, err := isReady(check)
+		if err != nil {
+			fmt.Fprintln(r.stderr, "Ready check error:", err)
+			return
+		}
+		if ready {
+			if r.markReady(p, gen) {
+				fmt.Fprintf(r.stdout, "=== %s is ready\n", target.Name)
+			}
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	if r.isCurrent(p, gen) {
+		fmt.Fprintf(r.stderr, "=== %s is not ready in %s\n", target.Name, timeout)
+	}
+}
+
+// scheduleRestart restarts the exited process later according the restart policy of the target,
+// it returns false if the process will not be restarted
+func (r *targetRunner) scheduleRestart(target *Target, success bool) bool {
+	switch target.Run.Restart {
+	case restartAlways:
+	case restartOnFailure:
+		if success {
+			return false
+		}
+	default:
+		return false
+	}
+
+	r.processLock.Lock()
+	if r.closed {
+		r.processLock.Unlock()
+		return false
+	}
+	if target.Run.MaxRestarts > 0 && r.restarts >= target.Run.MaxRestarts {
+		r.processLock.Unlock()
+		fmt.Fprintf(r.stderr, "=== %s has been restarted %d times, giving up\n", target.Name, r.restarts)
+		// the requests held by the failed restarts are not held anymore
+		if r.proxy != nil {
+			r.proxy.release(false)
+		}
+		return false
+	}
+	delay := restartBackoff(target.Run.Backoff, r.restarts)
+	r.restarts++
+	restarts := r.restarts
+	gen := r.generation
+	r.processLock.Unlock()
+
+	fmt.Fprintf(r.stdout, "=== Restarting %s in %s (restart %d)\n", target.Name, delay, restarts)
+	// the browsers wait the restarted process instead of getting connection refused during the backoff
+	if r.proxy != nil {
+		r.proxy.hold()
+	}
+	time.AfterFunc(delay, func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		// the process may be started by a rebuild or gop is stopping
+		r.processLock.Lock()
+		skip := r.closed || r.generation != gen || r.process != nil
+		r.processLock.Unlock()
+		if skip {
+			if r.proxy != nil {
+				r.proxy.release(false)
+			}
+			return
+		}
+
+		// if the process cannot be started, the next restart has held the proxy again or released it
+		r.start()
+		if r.proxy != nil && r.isRunning() {
+			r.proxy.release(true)
+		}
+	})
+	return true
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, restartBackoff(0, 0))
+	assert.Equal(t, 4*time.Second, restartBackoff(0, 2))
+	assert.Equal(t, 2*time.Second, restartBackoff(500*time.Millisecond, 2))
+	assert.Equal(t, maxRestartBackoff, restartBackoff(time.Second, 10))
+}
+
+func TestIsReady(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+
+	ready, err := isReady("tcp://" + addr)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+
+	l.Close()
+	ready, err = isReady("tcp://" + addr)
+	assert.NoError(t, err)
+	assert.False(t, ready)
+
+	_, err = isReady("udp://" + addr)
+	assert.Error(t, err)
+}
+
+func TestScheduleRestartHoldsProxy(t *testing.T) {
+	r := &targetRunner{stdout: ioutil.Discard, stderr: ioutil.Discard, proxy: newLiveReloadProxy(0, "")}
+	r.proxy.release(false)
+	isHeld := func() bool {
+		select {
+		case <-r.proxy.ready:
+			return false
+		default:
+			return true
+		}
+	}
+
+	// the requests are held during the backoff
+	target := &Target{}
+	target.Run.Restart = restartAlways
+	target.Run.MaxRestarts = 1
+	target.Run.Backoff = time.Hour
+	assert.True(t, r.scheduleRestart(target, false))
+	assert.True(t, isHeld())
+
+	// and released once gop gives up
+	assert.False(t, r.scheduleRestart(target, false))
+	assert.False(t, isHeld())
+}
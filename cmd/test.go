@@ -7,9 +7,14 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/urfave/cli"
 )
@@ -17,33 +22,76 @@ import (
 // CmdTest represents
 var CmdTest = cli.Command{
 	Name:            "test",
-	Usage:           "Run the target test codes",
-	Description:     `Run the target test codes`,
+	Usage:           "Run the test codes of the project packages",
+	Description:     `Run the test codes of all the project packages, or the packages of a target with --target`,
 	Action:          runTest,
 	SkipFlagParsing: true,
 }
 
 func runTest(ctx *cli.Context) error {
-	var args = ctx.Args()
-	var ensureFlagIdx = -1
-	for i, arg := range args {
-		if arg == "-v" {
-			showLog = true
-		} else if arg == "-e" {
-			ensureFlagIdx = i
+	var args = make([]string, 0, len(ctx.Args()))
+	var ensureFlag, watchFlag, watchDebug, coverFlag bool
+	var targetName, reportFlag string
+	var retries int
+	var excludes []string
+	var cmdArgs = ctx.Args()
+	for i := 0; i < len(cmdArgs); i++ {
+		arg := cmdArgs[i]
+		switch {
+		case arg == "-e":
+			ensureFlag = true
+		case arg == "-w":
+			watchFlag = true
+		case arg == "--watch-debug":
+			watchDebug = true
+		case arg == "--cover":
+			coverFlag = true
+		case arg == "--retries" || strings.HasPrefix(arg, "--retries="):
+			value := strings.TrimPrefix(arg, "--retries=")
+			if arg == "--retries" {
+				if i+1 >= len(cmdArgs) {
+					return fmt.Errorf("%s needs a value", arg)
+				}
+				i++
+				value = cmdArgs[i]
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("bad --retries %s", value)
+			}
+			retries = n
+		case arg == "--target" || arg == "--exclude" || arg == "--report":
+			if i+1 >= len(cmdArgs) {
+				return fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			switch arg {
+			case "--target":
+				targetName = cmdArgs[i]
+			case "--exclude":
+				excludes = append(excludes, cmdArgs[i])
+			default:
+				reportFlag = cmdArgs[i]
+			}
+		case strings.HasPrefix(arg, "--target="):
+			targetName = strings.TrimPrefix(arg, "--target=")
+		case strings.HasPrefix(arg, "--exclude="):
+			excludes = append(excludes, strings.TrimPrefix(arg, "--exclude="))
+		case strings.HasPrefix(arg, "--report="):
+			reportFlag = strings.TrimPrefix(arg, "--report=")
+		default:
+			if arg == "-v" {
+				showLog = true
+			}
+			args = append(args, arg)
 		}
 	}
 
-	if ensureFlagIdx > -1 {
-		args = append(args[:ensureFlagIdx], args[ensureFlagIdx+1:]...)
-	}
-
-	envs := os.Environ()
-	var gopathIdx = -1
-	for i, env := range envs {
-		if strings.HasPrefix(env, "GOPATH=") {
-			gopathIdx = i
-			break
+	var reports map[string]string
+	if reportFlag != "" {
+		var err error
+		if reports, err = parseReportFlag(reportFlag); err != nil {
+			return err
 		}
 	}
 
@@ -56,40 +104,120 @@ func runTest(ctx *cli.Context) error {
 		return err
 	}
 
-	var targetName string
-	if len(args) > 0 && !strings.HasPrefix(args[0], "-") && !strings.Contains(args[0], "/") {
+	if targetName == "" && len(args) > 0 && !strings.HasPrefix(args[0], "-") && !strings.Contains(args[0], "/") {
 		targetName = args[0]
 		args = args[1:]
 	}
 
-	if err = analysisTarget(level, targetName, projectRoot); err != nil {
+	target, err := analysisTarget(level, targetName, projectRoot)
+	if err != nil {
 		return err
 	}
 
-	if ensureFlagIdx > -1 {
+	if ensureFlag {
 		globalGoPath, ok := os.LookupEnv("GOPATH")
 		if !ok {
 			return errors.New("Not found GOPATH")
 		}
 
-		if err = ensure(ctx, globalGoPath, projectRoot, curTarget, true); err != nil {
+		if err = ensure(ctx, globalGoPath, projectRoot, target, true); err != nil {
 			return err
 		}
 	}
 
-	newGopath := fmt.Sprintf("GOPATH=%s", projectRoot)
-	if gopathIdx > 0 {
-		envs[gopathIdx] = newGopath
-	} else {
-		envs = append(envs, newGopath)
+	envs := projectEnv(projectRoot)
+
+	// only the packages of the target are tested if it's specified
+	var targetDir string
+	if targetName != "" {
+		targetDir = target.Dir
 	}
 
-	cmd := NewCommand("test").AddArguments(args...)
-	cmd.Env = envs
-	err = cmd.RunInDirPipeline(filepath.Join(projectRoot, "src", curTarget.Dir), os.Stdout, os.Stderr)
+	if watchFlag {
+		if coverFlag || reportFlag != "" || retries > 0 {
+			return errors.New("--cover, --report and --retries cannot be used with -w")
+		}
+		return watchTests(projectRoot, target, targetDir, excludes, args, envs, watchDebug)
+	}
+
+	graph, err := loadPackageGraph(projectRoot, buildTags(args))
 	if err != nil {
 		return err
 	}
+	pkgs := graph.selectPackages(targetDir, excludes)
+	if len(pkgs) == 0 {
+		return errors.New("no packages to test")
+	}
+
+	// the results of the tests are parsed from the output of go test -json, or go test -v before go 1.10
+	quarantine := config.Test.Quarantine
+	retrying := retries > 0 || len(quarantine) > 0
+	var stdout io.Writer = os.Stdout
+	var jsonOutput bool
+	if len(reports) > 0 || retrying {
+		jsonOutput = hasArg(args, "-json")
+		if !jsonOutput {
+			if version, err := retrieveGoVersion(); err == nil && goVersionAtLeast(version, 1, 10) {
+				args = append(args, "-json")
+				jsonOutput = true
+				stdout = newTestJSONWriter(os.Stdout)
+			} else if !hasArg(args, "-v") {
+				args = append(args, "-v")
+			}
+		}
+	}
 
-	return nil
+	start := time.Now()
+	var output []byte
+	var profile *coverProfile
+	if coverFlag {
+		output, profile, err = runCoverTests(projectRoot, graph, args, pkgs, envs, stdout)
+	} else {
+		output, err = runTestPackages(projectRoot, args, pkgs, envs, stdout, nil)
+	}
+	elapsed := time.Since(start)
+
+	var pkgReports []*packageReport
+	if len(reports) > 0 || retrying {
+		output, pkgReports = parseTestOutput(output, jsonOutput)
+	}
+	if retrying {
+		quarantineTests(pkgReports, quarantine)
+		retryFailedTests(projectRoot, args, pkgReports, retries, envs, jsonOutput, stdout)
+		printRetriedTests(os.Stdout, pkgReports)
+		// go test fails if any tests failed, but the flaky and quarantined ones are ignored.
+		// The other exit codes like 2 for the bad flags are kept.
+		if exitErr, ok := err.(*exec.ExitError); ok && !testsFailed(pkgReports) {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.ExitStatus() == 1 {
+				err = nil
+			}
+		}
+	}
+	if len(reports) > 0 {
+		if reportErr := writeTestReports(projectRoot, reports, pkgReports, elapsed); reportErr != nil {
+			if err != nil {
+				return exitStatusError(err)
+			}
+			return reportErr
+		}
+	}
+
+	results := parseTestResults(output)
+	ignoreRetriedFailures(results, pkgReports)
+	passed, failed := countTestResults(results)
+	fmt.Printf("\n=== Tested %d packages in %s, %d passed, %d failed\n",
+		len(pkgs), roundDuration(elapsed), passed, failed)
+	printTestResults(os.Stdout, results)
+	if profile == nil {
+		// the exit code of go test is kept, i.e. 2 for the bad flags
+		return exitStatusError(err)
+	}
+
+	coverages, total := profile.packages()
+	fmt.Printf("\n=== Coverage profile is written to %s\n", coverageProfilePath(projectRoot))
+	printCoverage(os.Stdout, coverages, total)
+	if err == nil && total.Percent() < config.Coverage.Min {
+		err = fmt.Errorf("the total coverage %.1f%% is lower than the minimum %.1f%%", total.Percent(), config.Coverage.Min)
+	}
+	return exitStatusError(err)
 }
@@ -0,0 +1,227 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// testResultRegexp matches the result lines of go test, i.e. ok  	models	0.01s
+var testResultRegexp = regexp.MustCompile(`^(ok|FAIL|\?)\s+(\S+)(?:\s+(.*))?$`)
+
+// coverageOnlyRegexp matches the lines of the packages without test files in cover mode,
+// i.e. 	models		coverage: 0.0% of statements
+var coverageOnlyRegexp = regexp.MustCompile(`^\t(\S+)\s+(coverage: .*)$`)
+
+// testResult is the result of a tested package
+type testResult struct {
+	Package string
+	Status  string
+	Detail  string
+}
+
+// parseTestResults parses the result lines of the packages in the output of go test
+func parseTestResults(output []byte) []testResult {
+	var results []testResult
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		matches := testResultRegexp.FindStringSubmatch(line)
+		if matches == nil {
+			if matches = coverageOnlyRegexp.FindStringSubmatch(line); matches != nil {
+				results = append(results, testResult{Package: matches[1], Status: "-", Detail: matches[2]})
+			}
+			continue
+		}
+
+		result := testResult{
+			Package: matches[2],
+			Status:  matches[1],
+			Detail:  strings.TrimSpace(matches[3]),
+		}
+		if result.Status == "?" {
+			result.Status = "-"
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// countTestResults returns the number of the passed and the failed packages,
+// the packages without test files are counted as passed
+func countTestResults(results []testResult) (passed, failed int) {
+	for _, result := range results {
+		if result.Status == "FAIL" {
+			failed++
+		} else {
+			passed++
+		}
+	}
+	return
+}
+
+// printTestResults prints the results as a table sorted by the package
+func printTestResults(w io.Writer, results []testResult) {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Package < results[j].Package
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tSTATUS\tDETAIL")
+	for _, result := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", result.Package, result.Status, result.Detail)
+	}
+	tw.Flush()
+}
+
+// runTestPackages runs go test for the packages in the src directory, the output is written to stdout
+// as well as returned. It returns ErrExecCancelled if cancel is closed before finishing.
+func runTestPackages(projectRoot string, args, pkgs, envs []string, stdout io.Writer, cancel <-chan struct{}) ([]byte, error) {
+	var output bytes.Buffer
+	w := io.MultiWriter(stdout, &output)
+	cmd := NewCommand("test").AddArguments(args...).AddArguments(pkgs...)
+	cmd.Env = envs
+	err := cmd.RunInDirCancelPipeline(cancel, filepath.Join(projectRoot, "src"), w, w)
+	return output.Bytes(), err
+}
+
+// intersectPackages returns the packages of pkgs which are also in selected
+func intersectPackages(pkgs, selected []string) []string {
+	var set = make(map[string]bool, len(selected))
+	for _, pkg := range selected {
+		set[pkg] = true
+	}
+
+	var result []string
+	for _, pkg := range pkgs {
+		if set[pkg] {
+			result = append(result, pkg)
+		}
+	}
+	return result
+}
+
+// changedDirs returns the directories of the changed files
+func changedDirs(files []string) []string {
+	var dirs []string
+	var seen = make(map[string]bool)
+	for _, f := range files {
+		dir := filepath.Dir(f)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// watchTests runs the tests of the selected packages, and then runs the tests of the changed packages and
+// the packages importing them on every change. A run in progress is cancelled when new changes arrive.
+func watchTests(projectRoot string, target *Target, targetDir string, excludes, args, envs []string, debug bool) error {
+	rules := newWatchRules(projectRoot, target, "")
+	watcher, err := newProjectWatcher(projectRoot, []*watchRules{rules}, target.Watch.Delay, debug)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	tags := buildTags(args)
+	graph, err := loadPackageGraph(projectRoot, tags)
+	if err != nil {
+		return err
+	}
+	pkgs := graph.selectPackages(targetDir, excludes)
+	if len(pkgs) == 0 {
+		return errors.New("no packages to test")
+	}
+
+	var (
+		dashboard = make(map[string]testResult)
+		cancel    chan struct{}
+		finished  chan struct{}
+	)
+
+	run := func(pkgs []string) {
+		cancel, finished = make(chan struct{}), make(chan struct{})
+		go func(cancel, finished chan struct{}) {
+			defer close(finished)
+
+			fmt.Printf("=== Testing %s\n", strings.Join(pkgs, " "))
+			start := time.Now()
+			output, err := runTestPackages(projectRoot, args, pkgs, envs, os.Stdout, cancel)
+			if err == ErrExecCancelled {
+				fmt.Println("=== Cancelled")
+				return
+			}
+
+			tested := parseTestResults(output)
+			for _, result := range tested {
+				dashboard[result.Package] = result
+			}
+			passed, failed := countTestResults(tested)
+
+			var results = make([]testResult, 0, len(dashboard))
+			for _, result := range dashboard {
+				results = append(results, result)
+			}
+			fmt.Printf("\n=== %s tested %d packages in %s, %d passed, %d failed\n",
+				time.Now().Format("15:04:05"), len(pkgs), roundDuration(time.Since(start)), passed, failed)
+			printTestResults(os.Stdout, results)
+		}(cancel, finished)
+	}
+
+	run(pkgs)
+
+	done := make(chan bool)
+	notifyDone(done)
+	for {
+		select {
+		case change := <-watcher.Changes:
+			graph, err := loadPackageGraph(projectRoot, tags)
+			if err != nil {
+				log.Println("Load packages error:", err)
+				continue
+			}
+
+			var affected = graph.selectPackages(targetDir, excludes)
+			if !change.Reload {
+				var files []string
+				for _, f := range change.Files {
+					if action, _ := rules.match(f); action != noNeedReBuildAndRun ||
+						(!graph.isProjectPackage(filepath.Dir(f)) && strings.HasSuffix(f, ".go")) {
+						files = append(files, f)
+					}
+				}
+				affected = intersectPackages(graph.affected(changedDirs(files)), affected)
+			}
+			if len(affected) == 0 {
+				continue
+			}
+
+			// the running tests are out of date
+			close(cancel)
+			<-finished
+			run(affected)
+		case err := <-watcher.Errors:
+			return err
+		case <-done:
+			close(cancel)
+			<-finished
+			return nil
+		}
+	}
+}
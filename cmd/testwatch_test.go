@@ -0,0 +1,28 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTestResults(t *testing.T) {
+	results := parseTestResults([]byte(`--- FAIL: TestUser (0.00s)
+    user_test.go:10: wrong name
+FAIL
+FAIL	models	0.003s
+ok  	routes	0.012s	coverage: 80.0% of statements
+?   	main	[no test files]
+	other		coverage: 0.0% of statements
+`))
+	assert.EqualValues(t, []testResult{
+		{Package: "models", Status: "FAIL", Detail: "0.003s"},
+		{Package: "routes", Status: "ok", Detail: "0.012s	coverage: 80.0% of statements"},
+		{Package: "main", Status: "-", Detail: "[no test files]"},
+		{Package: "other", Status: "-", Detail: "coverage: 0.0% of statements"},
+	}, results)
+}
@@ -282,3 +282,13 @@ func homeWindows() (string, error) {
 
 	return home, nil
 }
+
+// hasArg returns true if the arguments contain arg
+func hasArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
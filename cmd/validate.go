@@ -0,0 +1,452 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configIssue is a problem of gop.yml, the line and the column are 1-based and 0 if unknown
+type configIssue struct {
+	Line    int
+	Column  int
+	Message string
+	Warning bool
+}
+
+func (issue configIssue) String() string {
+	var pos = "gop.yml:"
+	if issue.Line > 0 {
+		pos += strconv.Itoa(issue.Line) + ":"
+		if issue.Column > 0 {
+			pos += strconv.Itoa(issue.Column) + ":"
+		}
+	}
+	if issue.Warning {
+		return pos + " warning: " + issue.Message
+	}
+	return pos + " " + issue.Message
+}
+
+// configPos is the position of a key or a sequence item in gop.yml
+type configPos struct {
+	Line   int
+	Column int
+}
+
+// configFrame is a mapping or a sequence of gop.yml being scanned, its children are indented by indent
+type configFrame struct {
+	indent int
+	path   string
+	items  int
+	// seq is true if the children are the sequence items
+	seq bool
+}
+
+var yamlLineRegexp = regexp.MustCompile(`^(yaml: )?line (\d+): (.*)$`)
+
+// yamlIssues converts the error of yaml.Unmarshal to issues
+func yamlIssues(err error, lines []string) []configIssue {
+	var msgs = []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		msgs = typeErr.Errors
+	}
+
+	var issues = make([]configIssue, 0, len(msgs))
+	for _, msg := range msgs {
+		m := yamlLineRegexp.FindStringSubmatch(msg)
+		if m == nil {
+			issues = append(issues, configIssue{Message: strings.TrimPrefix(msg, "yaml: ")})
+			continue
+		}
+
+		issue := configIssue{Message: m[3]}
+		issue.Line, _ = strconv.Atoi(m[2])
+		if m[1] != "" {
+			// the lines of the syntax errors are 0-based
+			issue.Line++
+		} else if issue.Line <= len(lines) {
+			issue.Column = valueColumn(lines[issue.Line-1])
+		}
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+// valueColumn returns the column of the value of a key or a sequence item line
+func valueColumn(line string) int {
+	content := strings.TrimLeft(line, " ")
+	col := len(line) - len(content)
+	for strings.HasPrefix(content, "- ") {
+		rest := strings.TrimLeft(content[1:], " ")
+		col += len(content) - len(rest)
+		content = rest
+	}
+	if key, _, ok := splitKey(content); ok {
+		rest := strings.TrimLeft(content[strings.Index(content, key)+len(key):], `"' `)
+		rest = strings.TrimLeft(strings.TrimPrefix(rest, ":"), " ")
+		col += len(content) - len(rest)
+	}
+	return col + 1
+}
+
+// isSeqItem returns true if the content of a line is a block sequence item
+func isSeqItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+// splitKey splits a mapping entry line into the key and the value without comment
+func splitKey(content string) (string, string, bool) {
+	if content == "" || strings.ContainsAny(content[:1], "[{#|>") {
+		return "", "", false
+	}
+
+	var key, rest string
+	if content[0] == '"' || content[0] == '\'' {
+		end := strings.IndexByte(content[1:], content[0])
+		if end < 0 || !strings.HasPrefix(content[end+2:], ":") {
+			return "", "", false
+		}
+		key, rest = content[1:end+1], content[end+3:]
+	} else {
+		idx := strings.Index(content+" ", ": ")
+		if idx < 0 {
+			return "", "", false
+		}
+		key, rest = strings.TrimRight(content[:idx], " "), content[idx+1:]
+	}
+
+	if rest != "" && rest[0] != ' ' {
+		return "", "", false
+	}
+	value := strings.TrimSpace(rest)
+	if strings.HasPrefix(value, "#") {
+		value = ""
+	}
+	return key, value, true
+}
+
+// configField returns the field of the struct decoded from the key like yaml.v2
+func configField(typ reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		if name == key {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func indirectType(typ reflect.Type) reflect.Type {
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// unknownKeys walks the decoded yaml like yaml.v2 decodes it into typ, it returns the paths of the keys
+// which are not in typ, i.e. targets[0].asets
+func unknownKeys(v interface{}, typ reflect.Type, path string) []string {
+	typ = indirectType(typ)
+	if typ == nil {
+		return nil
+	}
+
+	var unknowns []string
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		for _, item := range v {
+			key := fmt.Sprint(item.Key)
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+
+			var child reflect.Type
+			switch typ.Kind() {
+			case reflect.Struct:
+				f, ok := configField(typ, key)
+				if !ok {
+					unknowns = append(unknowns, childPath)
+					continue
+				}
+				child = f.Type
+			case reflect.Map:
+				child = typ.Elem()
+			default:
+				continue
+			}
+			unknowns = append(unknowns, unknownKeys(item.Value, child, childPath)...)
+		}
+	case []interface{}:
+		if typ.Kind() != reflect.Slice && typ.Kind() != reflect.Array {
+			return nil
+		}
+		for i, item := range v {
+			unknowns = append(unknowns, unknownKeys(item, typ.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return unknowns
+}
+
+// lookupPos returns the position of the path, the position of the parent is used if the path is not scanned
+func lookupPos(positions map[string]configPos, path string) configPos {
+	pos := positions[path]
+	for pos.Line == 0 && strings.ContainsAny(path, ".[") {
+		path = path[:strings.LastIndexAny(path, ".[")]
+		pos = positions[path]
+	}
+	return pos
+}
+
+// scanConfig scans the block style yaml, it returns the positions of the keys and the sequence items
+// by their paths like targets[0].assets[1]. Flow style collections are not scanned.
+func scanConfig(lines []string) map[string]configPos {
+	var (
+		positions   = make(map[string]configPos)
+		stack       = []*configFrame{{}}
+		pending     *configFrame
+		blockIndent = -1
+	)
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \r")
+		content := strings.TrimLeft(line, " ")
+		indent := len(line) - len(content)
+
+		// the lines of a block scalar are more indented than its key
+		if blockIndent >= 0 {
+			if content == "" || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		if content == "" || content[0] == '#' || content == "---" || content == "..." {
+			continue
+		}
+
+		// the key or the item without value has the children on the next lines,
+		// the items of a sequence could have the same indent as its key
+		if pending != nil {
+			if indent > pending.indent || (indent == pending.indent && isSeqItem(content)) {
+				pending.indent = indent
+				pending.seq = isSeqItem(content)
+				stack = append(stack, pending)
+			}
+			pending = nil
+		}
+
+		for {
+			seq := isSeqItem(content)
+			for len(stack) > 1 {
+				top := stack[len(stack)-1]
+				if top.indent < indent || (top.indent == indent && (seq || !top.seq)) {
+					break
+				}
+				stack = stack[:len(stack)-1]
+			}
+			top := stack[len(stack)-1]
+
+			if seq {
+				rest := strings.TrimLeft(content[1:], " ")
+				item := &configFrame{
+					indent: indent + len(content) - len(rest),
+					path:   fmt.Sprintf("%s[%d]", top.path, top.items),
+				}
+				top.items++
+				positions[item.path] = configPos{i + 1, item.indent + 1}
+
+				if rest == "" || rest[0] == '#' {
+					positions[item.path] = configPos{i + 1, indent + 1}
+					item.indent = indent
+					pending = item
+				} else if rest[0] == '|' || rest[0] == '>' {
+					blockIndent = indent
+				} else if _, _, ok := splitKey(rest); ok || isSeqItem(rest) {
+					stack = append(stack, item)
+					content, indent = rest, item.indent
+					continue
+				}
+				break
+			}
+
+			key, value, ok := splitKey(content)
+			if !ok {
+				break
+			}
+			path := key
+			if top.path != "" {
+				path = top.path + "." + key
+			}
+			positions[path] = configPos{i + 1, indent + 1}
+
+			if value == "" {
+				pending = &configFrame{indent: indent, path: path}
+			} else if value[0] == '|' || value[0] == '>' {
+				blockIndent = indent
+			}
+			break
+		}
+	}
+	return positions
+}
+
+// validate checks the targets and the groups of the config
+func (c *Config) validate(projectRoot string, positions map[string]configPos) []configIssue {
+	var issues []configIssue
+	add := func(warning bool, path, format string, a ...interface{}) {
+		pos := lookupPos(positions, path)
+		issues = append(issues, configIssue{pos.Line, pos.Column, fmt.Sprintf(format, a...), warning})
+	}
+
+	srcDir := filepath.Join(projectRoot, "src")
+	var names = make(map[string]bool, len(c.Targets))
+	for i, t := range c.Targets {
+		path := fmt.Sprintf("targets[%d]", i)
+		name := t.Name
+		if name == "" {
+			name = path
+			add(false, path, "%s has no name", path)
+		} else if names[name] {
+			add(false, path+".name", "duplicate target name %s", name)
+		}
+		names[name] = true
+
+		if t.Dir == "" {
+			add(false, path, "target %s has no dir", name)
+		} else if filepath.IsAbs(t.Dir) || isOutsideDir(t.Dir) {
+			add(false, path+".dir", "dir %s of target %s is not under src", t.Dir, name)
+		} else if !IsDir(filepath.Join(srcDir, t.Dir)) {
+			add(false, path+".dir", "dir %s of target %s does not exist under src", t.Dir, name)
+		} else {
+			for j, asset := range t.Assets {
+				if _, err := os.Stat(filepath.Join(srcDir, t.Dir, asset)); err != nil {
+					add(true, fmt.Sprintf("%s.assets[%d]", path, j), "asset %s of target %s does not exist", asset, name)
+				}
+			}
+		}
+
+		for j, monitor := range t.Monitors {
+			if filepath.IsAbs(monitor) || isOutsideDir(monitor) {
+				add(false, fmt.Sprintf("%s.monitors[%d]", path, j), "monitor %s is outside the directory of target %s", monitor, name)
+			}
+		}
+	}
+
+	var groups = make([]string, 0, len(c.Groups))
+	for group := range c.Groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		for j, name := range c.Groups[group] {
+			if !names[name] {
+				add(false, fmt.Sprintf("groups.%s[%d]", group, j), "unknown target %s in group %s", name, group)
+			}
+		}
+	}
+	return issues
+}
+
+// isOutsideDir returns true if the relative path goes out of its directory
+func isOutsideDir(p string) bool {
+	p = filepath.Clean(filepath.FromSlash(p))
+	return p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator))
+}
+
+// parseConfig decodes gop.yml into c and validates it, the issues are sorted by their positions
+func parseConfig(projectRoot string, bs []byte, c *Config) []configIssue {
+	lines := strings.Split(string(bs), "\n")
+	var issues []configIssue
+	if err := yaml.Unmarshal(bs, c); err != nil {
+		issues = yamlIssues(err, lines)
+		// the positions are not reliable if the syntax is wrong
+		if _, ok := err.(*yaml.TypeError); !ok {
+			return issues
+		}
+	}
+
+	// the unknown keys are found from the decoded yaml, so the flow style is also checked,
+	// the scanned lines only give their positions
+	positions := scanConfig(lines)
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(bs, &doc); err == nil {
+		for _, path := range unknownKeys(doc, reflect.TypeOf(*c), "") {
+			pos := lookupPos(positions, path)
+			msg := "unknown key " + path
+			if idx := strings.LastIndex(path, "."); idx > 0 {
+				msg = fmt.Sprintf("unknown key %s in %s", path[idx+1:], path[:idx])
+			}
+			issues = append(issues, configIssue{pos.Line, pos.Column, msg, true})
+		}
+	}
+	issues = append(issues, c.validate(projectRoot, positions)...)
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Line != issues[j].Line {
+			return issues[i].Line < issues[j].Line
+		}
+		return issues[i].Column < issues[j].Column
+	})
+	return issues
+}
+
+// configErrors returns the error of the issues which are not warnings
+func configErrors(issues []configIssue) error {
+	var errs []string
+	for _, issue := range issues {
+		if !issue.Warning {
+			errs = append(errs, issue.String())
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid gop.yml:\n%s", strings.Join(errs, "\n"))
+}
+
+func runConfigValidate(ctx *cli.Context) error {
+	_, projectRoot, reason, err := locateProject()
+	if err != nil {
+		return err
+	}
+	fmt.Println(reason)
+
+	bs, err := ioutil.ReadFile(filepath.Join(projectRoot, "gop.yml"))
+	if err != nil {
+		return err
+	}
+
+	var c Config
+	issues := parseConfig(projectRoot, bs, &c)
+	var errCount int
+	for _, issue := range issues {
+		fmt.Println(issue)
+		if !issue.Warning {
+			errCount++
+		}
+	}
+	if errCount > 0 {
+		return fmt.Errorf("%d errors and %d warnings in gop.yml", errCount, len(issues)-errCount)
+	}
+	fmt.Printf("gop.yml is valid, %d warnings\n", len(issues))
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfig(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gop-validate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	writeFile(t, tmpDir, "src/main/main.go", "package main\n")
+	writeFile(t, tmpDir, "src/main/public/index.html", "")
+
+	var c Config
+	issues := parseConfig(tmpDir, []byte(`targets:
+- name: app
+  dir: main
+  asets:
+  - public
+  assets:
+    - public
+    - templates
+  monitors: [../config.ini]
+  run:
+    port: abc
+- name: app
+  dir: web
+groups:
+  dev: [app, worker]
+monitor: x
+`), &c)
+
+	var msgs []string
+	for _, issue := range issues {
+		msgs = append(msgs, issue.String())
+	}
+	assert.EqualValues(t, []string{
+		"gop.yml:4:3: warning: unknown key asets in targets[0]",
+		"gop.yml:8:7: warning: asset templates of target app does not exist",
+		"gop.yml:9:3: monitor ../config.ini is outside the directory of target app",
+		"gop.yml:11:11: cannot unmarshal !!str `abc` into int",
+		"gop.yml:12:3: duplicate target name app",
+		"gop.yml:13:3: dir web of target app does not exist under src",
+		"gop.yml:15:3: unknown target worker in group dev",
+		"gop.yml:16:1: warning: unknown key monitor",
+	}, msgs)
+	assert.EqualValues(t, []string{"public", "templates"}, c.Targets[0].Assets)
+	assert.Error(t, configErrors(issues))
+
+	issues = parseConfig(tmpDir, []byte("targets:\n- name: app\n  dir: main\n b: [\n"), &Config{})
+	assert.EqualValues(t, []configIssue{{4, 0, "did not find expected key", false}}, issues)
+
+	issues = parseConfig(tmpDir, []byte("targets:\n- name: app\n  dir: main\n  description: |\n    name: x\n"), &Config{})
+	assert.EqualValues(t, []configIssue{{4, 3, "unknown key description in targets[0]", true}}, issues)
+	assert.NoError(t, configErrors(issues))
+
+	// the flow style keys are reported at the position of the parent
+	issues = parseConfig(tmpDir, []byte("targets: [{name: app, dir: main, asets: x, run: {prot: 80}}]\n"), &Config{})
+	assert.EqualValues(t, []configIssue{
+		{1, 1, "unknown key asets in targets[0]", true},
+		{1, 1, "unknown key prot in targets[0].run", true},
+	}, issues)
+}
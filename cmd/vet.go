@@ -5,6 +5,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -16,32 +17,33 @@ import (
 // CmdVet represents a vet command
 var CmdVet = cli.Command{
 	Name:        "vet",
-	Usage:       "Vet",
-	Description: `Vet`,
+	Usage:       "Check the project packages with go vet, the gop checks and the linters",
+	Description: `Check all the project packages, or the packages of a target with --target, with go vet, the gop checks and the linters of gop.yml`,
 	Action:      runVet,
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "verbose, v",
 			Usage: "Enables verbose progress and debug output",
 		},
+		cli.StringFlag{
+			Name:  "target",
+			Usage: "Only vets the packages of the target and its project dependencies",
+		},
+		cli.StringSliceFlag{
+			Name:  "exclude",
+			Usage: "Excludes the packages whose import paths match the glob pattern",
+		},
+		cli.BoolFlag{
+			Name:  "update-baseline",
+			Usage: "Writes all the issues found to the baseline as the known issues",
+		},
 	},
 }
 
 func runVet(ctx *cli.Context) error {
 	var args = ctx.Args()
-	for _, arg := range args {
-		if arg == "-v" {
-			showLog = true
-		}
-	}
-
-	envs := os.Environ()
-	var gopathIdx = -1
-	for i, env := range envs {
-		if strings.HasPrefix(env, "GOPATH=") {
-			gopathIdx = i
-			break
-		}
+	if ctx.Bool("verbose") {
+		showLog = true
 	}
 
 	level, projectRoot, err := analysisDirLevel()
@@ -53,29 +55,81 @@ func runVet(ctx *cli.Context) error {
 		return err
 	}
 
-	var targetName string
-	if len(args) > 0 && !strings.HasPrefix(args[0], "-") && !strings.Contains(args[0], "/") {
+	var targetName = ctx.String("target")
+	if targetName == "" && len(args) > 0 && !strings.HasPrefix(args[0], "-") && !strings.Contains(args[0], "/") {
 		targetName = args[0]
 		args = args[1:]
 	}
 
-	if err = analysisTarget(level, targetName, projectRoot); err != nil {
+	target, err := analysisTarget(level, targetName, projectRoot)
+	if err != nil {
 		return err
 	}
 
-	newGopath := fmt.Sprintf("GOPATH=%s", projectRoot)
-	if gopathIdx > 0 {
-		envs[gopathIdx] = newGopath
-	} else {
-		envs = append(envs, newGopath)
+	envs := projectEnv(projectRoot)
+
+	// only the packages of the target are vetted if it's specified
+	var targetDir string
+	if targetName != "" {
+		targetDir = target.Dir
+	}
+
+	graph, err := loadPackageGraph(projectRoot, buildTags(args))
+	if err != nil {
+		return err
+	}
+	pkgs := graph.selectPackages(targetDir, ctx.StringSlice("exclude"))
+	if len(pkgs) == 0 {
+		return errors.New("no packages to vet")
 	}
 
-	cmd := NewCommand("vet").AddArguments(args...)
-	cmd.Env = envs
-	err = cmd.RunInDirPipeline(filepath.Join(projectRoot, "src", curTarget.Dir), os.Stdout, os.Stderr)
+	enabled, err := enabledCheckers(config.Vet.Checks)
 	if err != nil {
 		return err
 	}
 
+	var issues []vetIssue
+	if enabled[checkerVet] {
+		vetIssues, err := runGoVet(projectRoot, args, pkgs, envs)
+		if err != nil {
+			return err
+		}
+		issues = append(issues, vetIssues...)
+	}
+	issues = append(issues, checkImports(projectRoot, pkgs, enabled)...)
+	for _, linter := range config.Vet.Linters {
+		lintIssues, err := runLinter(projectRoot, linter, pkgs, envs)
+		if err != nil {
+			return err
+		}
+		issues = append(issues, lintIssues...)
+	}
+	sortIssues(issues)
+
+	baselinePath := vetBaselinePath(projectRoot)
+	if ctx.Bool("update-baseline") {
+		if err = writeBaseline(baselinePath, issues); err != nil {
+			return err
+		}
+		fmt.Printf("=== %d issues are written to the baseline %s\n", len(issues), baselinePath)
+		return nil
+	}
+
+	baseline, err := loadBaseline(baselinePath)
+	if err != nil {
+		return err
+	}
+	issues, known := filterBaseline(issues, baseline)
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+
+	results := vetResults(issues, pkgs)
+	passed, failed := countTestResults(results)
+	fmt.Printf("\n=== Vetted %d packages, %d passed, %d failed, %d known issues are in the baseline\n", len(pkgs), passed, failed, known)
+	printTestResults(os.Stdout, results)
+	if len(issues) > 0 {
+		return fmt.Errorf("%d issues are found", len(issues))
+	}
 	return nil
 }
@@ -0,0 +1,150 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// defaultWatchInclude are the files which trigger rebuilding if include is not configured
+	defaultWatchInclude = []string{"**/*.go"}
+	// defaultWatchExclude are always excluded, such as logs and the swap files of editors
+	defaultWatchExclude = []string{"*.log", "*~", "*.swp", "*.swx", "*.swo", ".#*", "#*#", "4913", "*.tmp", ".DS_Store"}
+	// defaultWatchIgnoreDirs are never watched
+	defaultWatchIgnoreDirs = []string{".git", ".hg", ".svn", "vendor"}
+)
+
+// matchGlob reports whether name matches the shell pattern, the pattern could include ** which
+// matches zero or more directories. A pattern without / matches the base name of any file.
+// Both the pattern and the name use / as separator.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(name))
+		return matched
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(patterns, names []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			// ** at the end matches everything left
+			if len(patterns) == 1 {
+				return true
+			}
+			for i := 0; i <= len(names); i++ {
+				if matchSegments(patterns[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(names) == 0 {
+			return false
+		}
+		matched, err := path.Match(patterns[0], names[0])
+		if err != nil || !matched {
+			return false
+		}
+		patterns, names = patterns[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+func matchAnyGlob(patterns []string, name string) (string, bool) {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// watchRules decides which file changes should trigger rebuilding or restarting,
+// all the patterns are relative to the src directory of the project
+type watchRules struct {
+	srcDir      string
+	include     []string
+	exclude     []string
+	restartOnly []string
+	ignoreDirs  []string
+}
+
+// newWatchRules creates the watch rules from the watch section of the target and the defaults.
+// The configured include replaces the default one, others are appended to the defaults.
+func newWatchRules(projectRoot string, target *Target, exePath string) *watchRules {
+	srcDir := filepath.Join(projectRoot, "src")
+	rules := &watchRules{
+		srcDir:      srcDir,
+		include:     target.Watch.Include,
+		exclude:     append(append([]string{}, defaultWatchExclude...), target.Watch.Exclude...),
+		restartOnly: append([]string{}, target.Watch.RestartOnly...),
+		ignoreDirs:  append(append([]string{}, defaultWatchIgnoreDirs...), target.Watch.IgnoreDirs...),
+	}
+	if len(rules.include) == 0 {
+		rules.include = defaultWatchInclude
+	}
+
+	// the built binary itself should never trigger a change
+	if relPath, err := filepath.Rel(srcDir, exePath); err == nil && !strings.HasPrefix(relPath, "..") {
+		rules.exclude = append(rules.exclude, filepath.ToSlash(relPath), path.Join(path.Dir(filepath.ToSlash(relPath)), ".*.fingerprint"))
+	}
+
+	// monitors are the files relative to the target directory which only need restarting
+	for _, f := range target.Monitors {
+		rules.restartOnly = append(rules.restartOnly, path.Join(filepath.ToSlash(target.Dir), filepath.ToSlash(f)))
+	}
+	return rules
+}
+
+// relPath returns the slash separated path relative to the src directory
+func (rules *watchRules) relPath(fileName string) (string, bool) {
+	relPath, err := filepath.Rel(rules.srcDir, fileName)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(relPath), true
+}
+
+// isIgnoredDir returns true if the directory and all the files under it should not be watched
+func (rules *watchRules) isIgnoredDir(dir string) bool {
+	relPath, ok := rules.relPath(dir)
+	if !ok || relPath == "." {
+		return false
+	}
+	_, ignored := matchAnyGlob(rules.ignoreDirs, relPath)
+	return ignored
+}
+
+// match returns what should be done for the changed file and the reason
+func (rules *watchRules) match(fileName string) (int, string) {
+	relPath, ok := rules.relPath(fileName)
+	if !ok {
+		return noNeedReBuildAndRun, "outside of the src directory"
+	}
+
+	// check whether any parent directory is ignored
+	for dir := path.Dir(relPath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if pattern, ignored := matchAnyGlob(rules.ignoreDirs, dir); ignored {
+			return noNeedReBuildAndRun, fmt.Sprintf("directory %s is ignored by %q", dir, pattern)
+		}
+	}
+
+	if pattern, excluded := matchAnyGlob(rules.exclude, relPath); excluded {
+		return noNeedReBuildAndRun, fmt.Sprintf("excluded by %q", pattern)
+	}
+	if pattern, matched := matchAnyGlob(rules.restartOnly, relPath); matched {
+		return needReRun, fmt.Sprintf("restart only by %q", pattern)
+	}
+	if pattern, matched := matchAnyGlob(rules.include, relPath); matched {
+		return needReBuildAndRun, fmt.Sprintf("included by %q", pattern)
+	}
+	return noNeedReBuildAndRun, "not matched by any include or restart_only pattern"
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGlob(t *testing.T) {
+	var cases = []struct {
+		pattern string
+		name    string
+		matched bool
+	}{
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "main/main.go", true},
+		{"**/*.go", "main/routes/routes.go", true},
+		{"**/*.go", "main/templates/index.tmpl", false},
+		{"*.log", "main/logs/app.log", true},
+		{"main/templates/**", "main/templates/layouts/base.tmpl", true},
+		{"main/templates/**", "web/templates/index.tmpl", false},
+		{"main/**/*.tmpl", "main/index.tmpl", true},
+		{"main/*.tmpl", "main/layouts/index.tmpl", false},
+		{"**/gen/**", "models/gen/models.go", true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.matched, matchGlob(c.pattern, c.name), "%s %s", c.pattern, c.name)
+	}
+}
+
+func TestWatchRules(t *testing.T) {
+	projectRoot := filepath.FromSlash("/project")
+	target := &Target{
+		Name:     "web",
+		Dir:      "main",
+		Monitors: []string{"config.ini"},
+		Watch: WatchConfig{
+			Include:     []string{"**/*.go", "main/templates/**"},
+			Exclude:     []string{"**/*_gen.go"},
+			RestartOnly: []string{"main/public/**"},
+			IgnoreDirs:  []string{"node_modules"},
+		},
+	}
+	rules := newWatchRules(projectRoot, target, filepath.Join(projectRoot, "src", "main", "web"))
+
+	var cases = map[string]int{
+		"src/main/main.go":                 needReBuildAndRun,
+		"src/models/models.go":             needReBuildAndRun,
+		"src/main/templates/index.tmpl":    needReBuildAndRun,
+		"src/main/public/app.js":           needReRun,
+		"src/main/config.ini":              needReRun,
+		"src/main/web":                     noNeedReBuildAndRun,
+		"src/main/.web.fingerprint":        noNeedReBuildAndRun,
+		"src/models/models_gen.go":         noNeedReBuildAndRun,
+		"src/main/.main.go.swp":            noNeedReBuildAndRun,
+		"src/main/main.go~":                noNeedReBuildAndRun,
+		"src/vendor/github.com/a/b/b.go":   noNeedReBuildAndRun,
+		"src/main/node_modules/a/index.js": noNeedReBuildAndRun,
+		"src/.git/HEAD":                    noNeedReBuildAndRun,
+		"gop.yml":                          noNeedReBuildAndRun,
+		"src/main/README.md":               noNeedReBuildAndRun,
+	}
+	for name, expected := range cases {
+		needChange, reason := rules.match(filepath.Join(projectRoot, filepath.FromSlash(name)))
+		assert.Equal(t, expected, needChange, "%s: %s", name, reason)
+	}
+
+	assert.True(t, rules.isIgnoredDir(filepath.Join(projectRoot, "src", "vendor")))
+	assert.True(t, rules.isIgnoredDir(filepath.Join(projectRoot, "src", "main", "node_modules")))
+	assert.False(t, rules.isIgnoredDir(filepath.Join(projectRoot, "src", "main")))
+	assert.False(t, rules.isIgnoredDir(filepath.Join(projectRoot, "src")))
+}
@@ -0,0 +1,279 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	fsnotify "gopkg.in/fsnotify.v1"
+)
+
+// defaultWatchDelay is the quiet time after the last file event before a change is delivered
+const defaultWatchDelay = 300 * time.Millisecond
+
+// watchChange is a batch of file changes which happened in the debounce delay
+type watchChange struct {
+	// Action is needReBuildAndRun if any file needs rebuilding, or needReRun if only restarting is needed
+	Action int
+	// Ensure is true if the vendor directory changed
+	Ensure bool
+	// Reload is true if gop.yml changed
+	Reload bool
+	Files  []string
+}
+
+func (c *watchChange) merge(other watchChange) {
+	if other.Action == needReBuildAndRun || c.Action == noNeedReBuildAndRun {
+		if other.Action != noNeedReBuildAndRun {
+			c.Action = other.Action
+		}
+	}
+	c.Ensure = c.Ensure || other.Ensure
+	c.Reload = c.Reload || other.Reload
+	c.Files = append(c.Files, other.Files...)
+}
+
+func (c *watchChange) isEmpty() bool {
+	return c.Action == noNeedReBuildAndRun && !c.Ensure && !c.Reload
+}
+
+// isIgnoredByAll returns true if the directory is ignored by all the rules
+func isIgnoredByAll(rules []*watchRules, dir string) bool {
+	for _, r := range rules {
+		if !r.isIgnoredDir(dir) {
+			return false
+		}
+	}
+	return len(rules) > 0
+}
+
+// matchRules returns the strongest action of all the rules for the changed file,
+// rebuilding is stronger than restarting
+func matchRules(rules []*watchRules, fileName string) (int, string) {
+	var action = noNeedReBuildAndRun
+	var reason string
+	for _, r := range rules {
+		a, rs := r.match(fileName)
+		if reason == "" || a == needReBuildAndRun || (a == needReRun && action == noNeedReBuildAndRun) {
+			action, reason = a, rs
+		}
+		if action == needReBuildAndRun {
+			break
+		}
+	}
+	return action, reason
+}
+
+// projectWatcher watches the src directory recursively and the project root for gop.yml,
+// and delivers debounced changes
+type projectWatcher struct {
+	watcher     *fsnotify.Watcher
+	projectRoot string
+	delay       time.Duration
+	debug       bool
+
+	rulesLock sync.RWMutex
+	rules     []*watchRules
+
+	// Changes receives the debounced changes
+	Changes chan watchChange
+	// Errors receives the errors of the underlying watcher
+	Errors chan error
+}
+
+func newProjectWatcher(projectRoot string, rules []*watchRules, delay time.Duration, debug bool) (*projectWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if delay <= 0 {
+		delay = defaultWatchDelay
+	}
+
+	w := &projectWatcher{
+		watcher:     watcher,
+		projectRoot: projectRoot,
+		delay:       delay,
+		debug:       debug,
+		rules:       rules,
+		Changes:     make(chan watchChange),
+		Errors:      make(chan error),
+	}
+
+	// watch the project root for gop.yml, editors may replace the file so the directory is watched
+	if err = watcher.Add(projectRoot); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	if _, err = w.addTree(filepath.Join(projectRoot, "src")); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go w.loop()
+	return w, nil
+}
+
+// Close stops watching
+func (w *projectWatcher) Close() error {
+	return w.watcher.Close()
+}
+
+func (w *projectWatcher) getRules() []*watchRules {
+	w.rulesLock.RLock()
+	defer w.rulesLock.RUnlock()
+	return w.rules
+}
+
+// SetRules replaces the watch rules, i.e. after gop.yml reloaded
+func (w *projectWatcher) SetRules(rules []*watchRules) {
+	w.rulesLock.Lock()
+	w.rules = rules
+	w.rulesLock.Unlock()
+}
+
+func (w *projectWatcher) vendorDir() string {
+	return filepath.Join(w.projectRoot, "src", "vendor")
+}
+
+func (w *projectWatcher) isVendorPath(p string) bool {
+	vendorDir := w.vendorDir()
+	return p == vendorDir || strings.HasPrefix(p, vendorDir+string(filepath.Separator))
+}
+
+// addTree watches the directory and all of its subdirectories which are not ignored,
+// it returns the files found in the tree
+func (w *projectWatcher) addTree(root string) ([]string, error) {
+	var files []string
+	rules := w.getRules()
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// the directory may be removed during walking
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			return nil
+		}
+		// vendor is ignored by the rules but watched to ensure the dependencies
+		if !w.isVendorPath(p) && isIgnoredByAll(rules, p) {
+			return filepath.SkipDir
+		}
+		return w.watcher.Add(p)
+	})
+	return files, err
+}
+
+// classify returns the change of one file event
+func (w *projectWatcher) classify(event fsnotify.Event) watchChange {
+	var change = watchChange{Files: []string{event.Name}}
+	var reason string
+	switch {
+	case event.Op == fsnotify.Chmod:
+		reason = "only the file mode changed"
+	case filepath.Dir(event.Name) == w.projectRoot:
+		if filepath.Base(event.Name) == "gop.yml" {
+			change.Action = needReBuildAndRun
+			change.Reload = true
+			change.Ensure = true
+			reason = "gop.yml changed, reload and ensure"
+		} else {
+			reason = "outside of the src directory"
+		}
+	case w.isVendorPath(event.Name):
+		if strings.HasSuffix(event.Name, ".go") && !strings.HasSuffix(event.Name, "_test.go") {
+			change.Action = needReBuildAndRun
+			change.Ensure = true
+			reason = "vendored package changed, ensure"
+		} else {
+			reason = "not a vendored go file"
+		}
+	default:
+		change.Action, reason = matchRules(w.getRules(), event.Name)
+	}
+
+	if w.debug {
+		fmt.Printf("=== [watch] %s: %s\n", event, reason)
+	}
+	return change
+}
+
+// handle processes one file event and returns the change
+func (w *projectWatcher) handle(event fsnotify.Event) watchChange {
+	var change watchChange
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		// the watch of a removed or moved directory is useless, the new name will get a create event
+		w.watcher.Remove(event.Name)
+	}
+
+	if event.Op&fsnotify.Create != 0 && IsDir(event.Name) {
+		if filepath.Dir(event.Name) == w.projectRoot {
+			return change
+		}
+		// the files may be created before the new directory is watched, so they are checked here
+		files, err := w.addTree(event.Name)
+		if err != nil {
+			w.Errors <- err
+			return change
+		}
+		for _, f := range files {
+			change.merge(w.classify(fsnotify.Event{Name: f, Op: fsnotify.Create}))
+		}
+		return change
+	}
+
+	return w.classify(event)
+}
+
+// loop debounces the events, a change will be delivered after no events in the delay.
+// The events happen while the change is not received are merged into the next change.
+func (w *projectWatcher) loop() {
+	var (
+		pending watchChange
+		ready   watchChange
+		timer   = time.NewTimer(w.delay)
+		timerC  <-chan time.Time
+		out     chan watchChange
+	)
+	timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			change := w.handle(event)
+			if change.isEmpty() {
+				continue
+			}
+			pending.merge(change)
+			timer.Reset(w.delay)
+			timerC = timer.C
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.Errors <- err
+		case <-timerC:
+			timerC = nil
+			ready.merge(pending)
+			pending = watchChange{}
+			out = w.Changes
+		case out <- ready:
+			ready = watchChange{}
+			out = nil
+		}
+	}
+}
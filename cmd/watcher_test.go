@@ -0,0 +1,75 @@
+// Copyright 2019 The Gop Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectWatcher(t *testing.T) {
+	tmpDir := filepath.Join(os.TempDir(), fmt.Sprintf("%d", time.Now().UnixNano()))
+	defer os.RemoveAll(tmpDir)
+
+	writeFile(t, tmpDir, "gop.yml", "targets:\n- name: app\n  dir: main\n")
+	writeFile(t, tmpDir, "src/main/main.go", "package main\n")
+	writeFile(t, tmpDir, "src/vendor/github.com/a/b/b.go", "package b\n")
+
+	target := &Target{Name: "app", Dir: "main"}
+	rules := []*watchRules{newWatchRules(tmpDir, target, filepath.Join(tmpDir, "src", "main", "app"))}
+	watcher, err := newProjectWatcher(tmpDir, rules, 50*time.Millisecond, false)
+	assert.NoError(t, err)
+	defer watcher.Close()
+
+	waitChange := func() watchChange {
+		select {
+		case change := <-watcher.Changes:
+			return change
+		case err := <-watcher.Errors:
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change received")
+		}
+		return watchChange{}
+	}
+
+	// files in a new subtree are found even if they are created before the directory is watched
+	writeFile(t, tmpDir, "src/models/user/user.go", "package user\n")
+	change := waitChange()
+	assert.Equal(t, needReBuildAndRun, change.Action)
+	assert.False(t, change.Ensure)
+
+	// the following writes are debounced into one change
+	writeFile(t, tmpDir, "src/main/main.go", "package main\n\nfunc main() {}\n")
+	writeFile(t, tmpDir, "src/main/.main.go.swp", "")
+	writeFile(t, tmpDir, "src/vendor/github.com/a/b/b.go", "package b\n\nfunc B() {}\n")
+	change = waitChange()
+	assert.Equal(t, needReBuildAndRun, change.Action)
+	assert.True(t, change.Ensure)
+	assert.False(t, change.Reload)
+
+	writeFile(t, tmpDir, "gop.yml", "targets:\n- name: app\n  dir: main\n  watch:\n    delay: 1s\n")
+	change = waitChange()
+	assert.True(t, change.Reload)
+	assert.True(t, change.Ensure)
+}
+
+func TestWatchChangeMerge(t *testing.T) {
+	var change watchChange
+	assert.True(t, change.isEmpty())
+
+	change.merge(watchChange{Action: needReRun})
+	assert.Equal(t, needReRun, change.Action)
+	change.merge(watchChange{Action: needReBuildAndRun})
+	assert.Equal(t, needReBuildAndRun, change.Action)
+	change.merge(watchChange{Action: needReRun, Ensure: true})
+	assert.Equal(t, needReBuildAndRun, change.Action)
+	assert.True(t, change.Ensure)
+}
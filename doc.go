@@ -56,6 +56,17 @@ This is an example project's directory tree.
 					├── log
 					└── tango
 
+gop could run in any directory of the project, the project root is the nearest directory with gop.yml
+walking up from the working directory with the symlinks resolved, or set by GOP_PROJECT or the global
+--project flag. Under src/<target dir>/..., the target containing the directory is used. gop env and
+gop config validate show which project root is chosen and why.
+
+	gop --project ~/work/myproject build
+
+A project could also live inside the global GOPATH, then GOPATH is the project root followed by the global
+GOPATH. The project packages imported through the global GOPATH like github.com/me/myproject/src/models
+are not copied into vendor, but gop ensure warns about them and gop vet reports them as ambiguous.
+
 Gop.yml
 
 Gop will recognize a gop project which has gop.yml. The file is also a project configuration file.
@@ -78,6 +89,12 @@ name is the project name.
 			- public
 			- config.ini
 
+Every command validates gop.yml, the unknown keys and the missing assets are warned. The syntax errors,
+the targets without name or dir, the duplicate target names, the target dirs not existing under src,
+the monitors outside the target and the unknown targets of the groups are reported with the lines and columns.
+
+	gop config validate
+
 Command
 
 1. init
@@ -123,7 +140,14 @@ Remove one or more packages from this project.
 
 Run go build on the src directory. If you want to execute ensure before build, you can use `-e` flag.
 
-	gop build [-e] [target_name]
+	gop build [-e] [--force] [target_name]
+
+If the target's sources, vendored dependencies, build flags and go version are not changed since
+last build, the build will be skipped. --force will always build the target.
+
+--all will build all the targets concurrently, -j limits how many targets are built at the same time.
+
+	gop build --all [-j 4] [-e]
 
 8. run
 
@@ -132,17 +156,124 @@ automatically build and run again. `-e` will automatically execute `ensure` befo
 
 	gop run [-w] [-e] [target_name]
 
+On restarting, the old process group receives the stop_signal (default SIGTERM) of the run section
+in gop.yml and will be killed after the grace_period. The new process waits the port to be released.
+
+The arguments after -- are passed to the program. The args, env, env_file and workdir of the run section
+in gop.yml are also applied, a .env file in the target directory or the project root is loaded by default.
+
+	gop run [target_name] [build flags] -- [program args]
+
+The watch section of gop.yml configures the glob patterns, relative to src, of the files which trigger
+rebuilding (include), restarting only (restart_only), nothing (exclude) and the ignored directories
+(ignore_dirs). --watch-debug explains why every file event did or did not trigger. The changes are
+handled after no file events in the delay of the watch section, default is 300ms. Changes under
+src/vendor run ensure before rebuilding and changes of gop.yml reload the configuration.
+The old process keeps running until the rebuild succeeds, the compiler errors are written to
+bin/<target>.errors.json. A process exited by itself is restarted according the restart policy of the
+run section (never, on-failure or always) with exponential backoff, until max_restarts is reached.
+The restart count is reset once the ready_check (http:// or tcp://) passes.
+
+	gop run -w --watch-debug
+
+--all runs all the targets together and --group runs the targets of a group in the groups section of
+gop.yml, the output lines are prefixed with the target names. In watch mode every target is only rebuilt
+when the packages it depends on changed.
+
+	gop run -w --all
+	gop run -w --group dev
+
+--proxy starts a live reload proxy in front of the port of the run section. The requests are held while
+the target is restarting, and the browsers reload once the new process answers the health_check path.
+
+	gop run -w --proxy :3000
+
 9. test
 
-Run go test on the src directory. If you want to execute ensure before build, you can use `-e` flag.
+Run go test on all the project packages under src except the vendored ones. --target only tests the packages
+of the target and the project packages it imports, --exclude skips the packages matching the glob pattern.
+gop vet selects the packages in the same way.
+
+	gop test [-e] [--target target_name] [--exclude pattern] [go test flags]
+	gop vet [--target target_name] [--exclude pattern]
+
+--cover merges the coverage profiles of the project packages into bin/coverage.out with an HTML report
+bin/coverage.html, and fails if the total coverage is lower than coverage.min of gop.yml.
+
+	gop test --cover
+
+gop vet runs go vet, the gop checks of the imports and the linters of gop.yml, the issues in the baseline file
+are not reported. --update-baseline writes the current issues to the baseline.
+
+	gop vet [--update-baseline]
+
+--report writes the JUnit XML or JSON report of the tests, the packages which cannot be built are reported as errors.
 
-	gop test [-e] [target_name]
+	gop test --report junit=bin/report.xml,json=bin/report.json
 
-10. release
+--retries reruns the failed tests, the tests passed in a rerun are flaky and don't fail gop test. The failures of
+the tests in test.quarantine of gop.yml are ignored.
+
+	gop test --retries 2
+
+-w runs the tests of the changed packages and the project packages importing them on every change,
+a running test is cancelled when new changes arrive.
+
+	gop test -w [target_name] [go test flags]
+
+10. fmt
+
+Format the go files under src except vendor like gofmt -s, --imports also groups the imports like goimports.
+--check lists the unformatted files with the diffs and fails if there are any.
+
+	gop fmt [--imports] [--check]
+
+11. bench
+
+Run the benchmarks and save the results into bin/bench/<git-sha>.json, --baseline compares them with the results
+of a commit like benchstat, and fails if a significant regression is more than bench.threshold of gop.yml.
+
+	gop bench [target_name|package] [--bench regexp] [--count n] [--baseline git-sha|file]
+
+12. generate
+
+Run go generate on the project packages with the project GOPATH, the commands under src/tools and the vendored
+commands run by //go:generate, or the tools of gop.yml, are built into bin/tools and put on PATH.
+The changed files are reported after generation.
+
+	gop generate [target_name|./...] [go generate flags]
+
+13. env
+
+Print the project environment, GOPATH is the project root and bin/tools is put on PATH, as shell export lines or JSON.
+
+	gop env [--json]
+
+14. exec
+
+Run any command with the project environment in the current directory, the exit code of the command is kept.
+Ctrl-C is handled by the command, so gop exec -- dlv debug works like dlv itself.
+
+	gop exec -- <command> [args]
+
+15. release
 
 Run go release on the src directory.
 
-	gop release [target_name]
+	gop release [--force] [target_name]
+
+--reproducible will build a byte-identical release, the modification times of the files are taken from
+SOURCE_DATE_EPOCH or the last commit. No archives are written, only the image tarball of --image is normalized too.
+Before go1.13, the given -gcflags and -asmflags should have the all= pattern like -gcflags=all=-N.
+--verify will build twice into temporary directories and diff the results.
+
+	gop release [--reproducible|--verify]
+
+--image will write a docker save compatible OCI image tarball of the target without a docker daemon,
+the image is configured by the image section of the target in gop.yml. The linux binary of the image is
+built in bin/.image/<target>, so bin/<target> is kept. It can't be used with --verify.
+
+	gop release --image
 
 */
 package main
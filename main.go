@@ -23,6 +23,13 @@ func main() {
 	app.Name = "gop"
 	app.Usage = "Build golang applications out of GOPATH"
 	app.Version = Version
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:        "project",
+			Usage:       "The project root, default is GOP_PROJECT or the nearest directory with gop.yml",
+			Destination: &cmd.ProjectFlag,
+		},
+	}
 	app.Commands = []cli.Command{
 		cmd.CmdInit,
 		cmd.CmdBuild,
@@ -37,6 +44,11 @@ func main() {
 		cmd.CmdDownload,
 		cmd.CmdConfig,
 		cmd.CmdVet,
+		cmd.CmdFmt,
+		cmd.CmdGenerate,
+		cmd.CmdBench,
+		cmd.CmdEnv,
+		cmd.CmdExec,
 	}
 
 	err := app.Run(os.Args)